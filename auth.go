@@ -0,0 +1,391 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	authBearer   = "bearer"
+	authAPIKey   = "api_key"
+	authESAPIKey = "es_api_key"
+	authAWSSigV4 = "aws_sigv4"
+	authOAuth2   = "oauth2"
+)
+
+// AuthConfig authenticates requests with a token, an API key, an AWS SigV4
+// signature or an OAuth2 token of client credentials.
+type AuthConfig struct {
+	// bearer, api_key, es_api_key, aws_sigv4 or oauth2
+	Type string `config:"type"`
+
+	// type: bearer
+	Token string `config:"token"`
+
+	// type: api_key, the key is sent in the header, default: X-API-Key
+	Header string `config:"header"`
+	Key    string `config:"key"`
+
+	// type: es_api_key, id and api_key of the Elasticsearch API key, or the
+	// base64 encoded `id:api_key`
+	ID      string `config:"id"`
+	APIKey  string `config:"api_key"`
+	Encoded string `config:"encoded"`
+
+	// type: aws_sigv4, credentials default to the AWS_* environment variables
+	Region          string `config:"region"`
+	Service         string `config:"service"`
+	AccessKeyID     string `config:"access_key_id"`
+	SecretAccessKey string `config:"secret_access_key"`
+	SessionToken    string `config:"session_token"`
+
+	// type: oauth2, the token is cached until it expires or a 401 response
+	TokenURL     string   `config:"token_url"`
+	ClientID     string   `config:"client_id"`
+	ClientSecret string   `config:"client_secret"`
+	Scopes       []string `config:"scopes"`
+
+	headerName  string
+	headerValue string
+
+	lock   sync.RWMutex
+	token  string
+	expiry time.Time
+}
+
+// oauth2ExpiryDelta refreshes OAuth2 tokens before they expire.
+const oauth2ExpiryDelta = 10 * time.Second
+
+func (a *AuthConfig) init() error {
+	switch a.Type {
+	case authBearer:
+		if a.Token == "" {
+			return fmt.Errorf("token of bearer auth is required")
+		}
+		a.headerName, a.headerValue = fasthttp.HeaderAuthorization, "Bearer "+a.Token
+	case authAPIKey:
+		if a.Key == "" {
+			return fmt.Errorf("key of api_key auth is required")
+		}
+		a.headerName, a.headerValue = a.Header, a.Key
+		if a.headerName == "" {
+			a.headerName = "X-API-Key"
+		}
+	case authESAPIKey:
+		encoded := a.Encoded
+		if encoded == "" {
+			if a.ID == "" || a.APIKey == "" {
+				return fmt.Errorf("id and api_key, or encoded of es_api_key auth are required")
+			}
+			encoded = base64.StdEncoding.EncodeToString([]byte(a.ID + ":" + a.APIKey))
+		}
+		a.headerName, a.headerValue = fasthttp.HeaderAuthorization, "ApiKey "+encoded
+	case authAWSSigV4:
+		if a.Region == "" {
+			a.Region = os.Getenv("AWS_REGION")
+		}
+		if a.Service == "" {
+			a.Service = "es"
+		}
+		if a.AccessKeyID == "" && a.SecretAccessKey == "" {
+			a.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			a.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			if a.SessionToken == "" {
+				a.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+			}
+		}
+		if a.Region == "" || a.AccessKeyID == "" || a.SecretAccessKey == "" {
+			return fmt.Errorf("region, access_key_id and secret_access_key of aws_sigv4 auth are required")
+		}
+	case authOAuth2:
+		if a.TokenURL == "" || a.ClientID == "" {
+			return fmt.Errorf("token_url and client_id of oauth2 auth are required")
+		}
+	default:
+		return fmt.Errorf("unsupported auth [%s]", a.Type)
+	}
+	return nil
+}
+
+// authConfig returns the auth of the request, or the runner default if the
+// request has no basic auth either.
+func (req *Request) authConfig(config *LoaderConfig) *AuthConfig {
+	if req.Auth != nil {
+		return req.Auth
+	}
+	if req.BasicAuth != nil && req.BasicAuth.Username != "" {
+		return nil
+	}
+	return config.RunnerConfig.DefaultAuth
+}
+
+// apply authenticates the prepared request, the body is signed as is, so it
+// must be applied after the body is rendered and compressed.
+func (a *AuthConfig) apply(req *fasthttp.Request, stream bodyStream) error {
+	switch a.Type {
+	case authOAuth2:
+		token, err := a.oauth2Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+	case authAWSSigV4:
+		hash := sha256.New()
+		if stream != nil {
+			reader := stream.open()
+			_, err := io.Copy(hash, reader)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+		} else {
+			hash.Write(req.Body())
+		}
+		a.signV4(req, hex.EncodeToString(hash.Sum(nil)), time.Now())
+	default:
+		req.Header.Set(a.headerName, a.headerValue)
+	}
+	return nil
+}
+
+// refresh drops the OAuth2 token rejected by a 401 response, and sets the new
+// token to req. It returns false if the auth can't be refreshed.
+func (a *AuthConfig) refresh(req *fasthttp.Request) bool {
+	if a.Type != authOAuth2 {
+		return false
+	}
+	rejected := strings.TrimPrefix(string(req.Header.Peek(fasthttp.HeaderAuthorization)), "Bearer ")
+	a.lock.Lock()
+	if a.token == rejected {
+		a.token = ""
+	}
+	a.lock.Unlock()
+	return a.apply(req, nil) == nil
+}
+
+// oauth2Client requests OAuth2 tokens, it's replaced by newOAuth2Client with
+// the runner TLS config before a run.
+var oauth2Client = &http.Client{Timeout: 30 * time.Second}
+
+// newOAuth2Client creates the client of OAuth2 tokens, connections are dialed
+// by dialConn, so that proxy, resolve overrides and local addresses of the
+// runner are used.
+func newOAuth2Client(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialConn(addr)
+			},
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: 30 * time.Second,
+	}
+}
+
+func (a *AuthConfig) oauth2Token() (string, error) {
+	a.lock.RLock()
+	token, expiry := a.token, a.expiry
+	a.lock.RUnlock()
+	if token != "" && time.Now().Before(expiry) {
+		return token, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// Refreshed by another thread
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	tokenReq, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	tokenResp, err := oauth2Client.Do(tokenReq)
+	if err != nil {
+		return "", err
+	}
+	defer tokenResp.Body.Close()
+	body, err := io.ReadAll(tokenResp.Body)
+	if err != nil {
+		return "", err
+	}
+	if tokenResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get oauth2 token from [%s]: %s, %s", a.TokenURL, tokenResp.Status, body)
+	}
+	result := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err = json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return "", fmt.Errorf("invalid oauth2 token response from [%s]: %s", a.TokenURL, body)
+	}
+
+	a.token = result.AccessToken
+	// Tokens without expires_in are used until a 401 response
+	a.expiry = time.Now().Add(100 * 365 * 24 * time.Hour)
+	if result.ExpiresIn > 0 {
+		a.expiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - oauth2ExpiryDelta)
+	}
+	return a.token, nil
+}
+
+// signV4 signs req with AWS Signature Version 4, the host, x-amz-date,
+// x-amz-content-sha256 and x-amz-security-token headers are signed.
+func (a *AuthConfig) signV4(req *fasthttp.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := [][2]string{
+		{"host", string(req.Host())},
+		{"x-amz-content-sha256", payloadHash},
+		{"x-amz-date", amzDate},
+	}
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+		headers = append(headers, [2]string{"x-amz-security-token", a.SessionToken})
+	}
+
+	path := string(req.URI().RequestURI())
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		path = "/"
+	}
+	// Paths are encoded twice except for S3
+	if a.Service != "s3" {
+		path = awsURIEncode(path, false)
+	}
+	query := map[string][]string{}
+	req.URI().QueryArgs().VisitAll(func(k, v []byte) {
+		query[string(k)] = append(query[string(k)], string(v))
+	})
+
+	canonical := awsCanonicalRequest(string(req.Header.Method()), path, query, headers, payloadHash)
+	scope := amzDate[:8] + "/" + a.Region + "/" + a.Service + "/aws4_request"
+	signature, signedHeaders := awsSignature(a.SecretAccessKey, amzDate, scope, canonical, headers)
+	req.Header.Set(fasthttp.HeaderAuthorization, fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsCanonicalRequest builds the canonical request, headers must be sorted by
+// lowercase names.
+func awsCanonicalRequest(method string, path string, query map[string][]string, headers [][2]string, payloadHash string) string {
+	// Parameters are sorted by the encoded name, then by the encoded value
+	pairs := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{awsURIEncode(k, true), awsURIEncode(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	params := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		params = append(params, pair[0]+"="+pair[1])
+	}
+
+	var builder strings.Builder
+	builder.WriteString(method + "\n" + path + "\n" + strings.Join(params, "&") + "\n")
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		builder.WriteString(header[0] + ":" + strings.TrimSpace(header[1]) + "\n")
+		names = append(names, header[0])
+	}
+	builder.WriteString("\n" + strings.Join(names, ";") + "\n" + payloadHash)
+	return builder.String()
+}
+
+func awsSignature(secret string, amzDate string, scope string, canonical string, headers [][2]string) (signature string, signedHeaders string) {
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		names = append(names, header[0])
+	}
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	parts := strings.Split(scope, "/")
+	key := []byte("AWS4" + secret)
+	for _, part := range parts {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign)), strings.Join(names, ";")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode encodes all bytes except the unreserved characters of RFC 3986,
+// slashes are kept unless encodeSlash.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
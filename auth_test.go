@@ -0,0 +1,179 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestStaticAuth(t *testing.T) {
+	tests := []struct {
+		auth          *AuthConfig
+		header, value string
+	}{
+		{&AuthConfig{Type: authBearer, Token: "abc"}, "Authorization", "Bearer abc"},
+		{&AuthConfig{Type: authAPIKey, Key: "abc"}, "X-API-Key", "abc"},
+		{&AuthConfig{Type: authAPIKey, Header: "apikey", Key: "abc"}, "apikey", "abc"},
+		{&AuthConfig{Type: authESAPIKey, ID: "VuaCfGcBCdbkQm-e5aOx", APIKey: "ui2lp2axTNmsyakw9tvNnw"}, "Authorization", "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="},
+		{&AuthConfig{Type: authESAPIKey, Encoded: "abc=="}, "Authorization", "ApiKey abc=="},
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	for _, test := range tests {
+		auth := test.auth
+		if err := auth.init(); err != nil {
+			t.Fatal(err)
+		}
+		req.Reset()
+		if err := auth.apply(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if v := string(req.Header.Peek(test.header)); v != test.value {
+			t.Errorf("expected %s: %s, got %s", test.header, test.value, v)
+		}
+	}
+
+	for _, auth := range []*AuthConfig{{Type: "digest"}, {Type: authBearer}, {Type: authESAPIKey, ID: "id"}, {Type: authOAuth2}} {
+		if err := auth.init(); err == nil {
+			t.Errorf("expected error of %+v", auth)
+		}
+	}
+}
+
+// The get-vanilla case of the AWS SigV4 test suite.
+func TestAWSSignature(t *testing.T) {
+	headers := [][2]string{{"host", "example.amazonaws.com"}, {"x-amz-date", "20150830T123600Z"}}
+	emptyHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	canonical := awsCanonicalRequest("GET", "/", nil, headers, emptyHash)
+	expected := "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" + emptyHash
+	if canonical != expected {
+		t.Errorf("unexpected canonical request: %q", canonical)
+	}
+	signature, signedHeaders := awsSignature("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830T123600Z", "20150830/us-east-1/service/aws4_request", canonical, headers)
+	if signature != "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31" || signedHeaders != "host;x-amz-date" {
+		t.Errorf("unexpected signature: %s, %s", signature, signedHeaders)
+	}
+
+	query := map[string][]string{"b": {"2"}, "a": {"x y", "1"}}
+	canonical = awsCanonicalRequest("POST", "/", query, headers, emptyHash)
+	if !strings.HasPrefix(canonical, "POST\n/\na=1&a=x%20y&b=2\n") {
+		t.Errorf("unexpected canonical query: %q", canonical)
+	}
+
+	// A name that is a prefix of another name sorts first
+	query = map[string][]string{"a-b": {"1"}, "a": {"2"}}
+	canonical = awsCanonicalRequest("GET", "/", query, headers, emptyHash)
+	if !strings.HasPrefix(canonical, "GET\n/\na=2&a-b=1\n") {
+		t.Errorf("unexpected canonical query: %q", canonical)
+	}
+}
+
+func TestSignV4(t *testing.T) {
+	auth := &AuthConfig{Type: authAWSSigV4, Region: "us-east-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	if err := auth.init(); err != nil {
+		t.Fatal(err)
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI("https://search.us-east-1.es.amazonaws.com/my-index/_bulk?refresh=true")
+	req.SetBodyString(`{"index":{}}` + "\n")
+	if err := auth.apply(req, nil); err != nil {
+		t.Fatal(err)
+	}
+	authorization := string(req.Header.Peek(fasthttp.HeaderAuthorization))
+	date := time.Now().UTC().Format("20060102")
+	prefix := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/%s/us-east-1/es/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=", date)
+	if !strings.HasPrefix(authorization, prefix) || len(authorization) != len(prefix)+64 {
+		t.Errorf("unexpected authorization: %s", authorization)
+	}
+	hash := sha256.Sum256(req.Body())
+	if v := string(req.Header.Peek("X-Amz-Content-Sha256")); v != hex.EncodeToString(hash[:]) {
+		t.Errorf("unexpected payload hash: %s", v)
+	}
+	if v := string(req.Header.Peek("X-Amz-Security-Token")); v != "token" {
+		t.Errorf("unexpected security token: %s", v)
+	}
+}
+
+func TestOAuth2Token(t *testing.T) {
+	var calls int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "loadgen" || secret != "secret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer server.Close()
+
+	// Tokens are requested over connections of dialConn with the runner TLS config
+	oauth2Client = newOAuth2Client(server.Client().Transport.(*http.Transport).TLSClientConfig)
+	defer func() { oauth2Client = &http.Client{Timeout: 30 * time.Second} }()
+	opened := atomic.LoadInt64(&connStats.opened)
+
+	auth := &AuthConfig{Type: authOAuth2, TokenURL: server.URL, ClientID: "loadgen", ClientSecret: "secret", Scopes: []string{"read", "write"}}
+	if err := auth.init(); err != nil {
+		t.Fatal(err)
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	for i := 0; i < 3; i++ {
+		if err := auth.apply(req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := string(req.Header.Peek(fasthttp.HeaderAuthorization)); v != "Bearer token-1" || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected cached token, got %s after %v calls", v, calls)
+	}
+	if atomic.LoadInt64(&connStats.opened) == opened {
+		t.Error("expected token connections dialed by dialConn")
+	}
+
+	if !auth.refresh(req) {
+		t.Fatal("expected token to be refreshed")
+	}
+	if v := string(req.Header.Peek(fasthttp.HeaderAuthorization)); v != "Bearer token-2" {
+		t.Errorf("expected refreshed token, got %s", v)
+	}
+
+	auth.ClientSecret = "invalid"
+	auth.token = ""
+	if err := auth.apply(req, nil); err == nil {
+		t.Error("expected error of invalid client")
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	bodyFileWhole           = "whole"
+	bodyFileSequentialChunk = "sequential_chunk"
+	bodyFileRandomChunk     = "random_chunk"
+)
+
+// bodyStream is a request body streamed while sending.
+type bodyStream interface {
+	setBody(req *fasthttp.Request)
+	// open returns a new reader of the body
+	open() io.ReadCloser
+}
+
+// bodyStreams keeps the body stream prepared with each request, so that it
+// can be sent again by `execute_repeat_times`, as fasthttp drops the body
+// stream once it's sent. The request is shared by the items of a VU, the
+// stream is removed once an item without body stream is prepared.
+var bodyStreams sync.Map
+
+// loadBodyStream returns the body stream prepared with r, nil if the body of r
+// is not streamed.
+func loadBodyStream(r *fasthttp.Request) bodyStream {
+	if body, ok := bodyStreams.Load(r); ok {
+		return body.(bodyStream)
+	}
+	return nil
+}
+
+// resetBodyStream sets the body stream of r again before it's resent.
+func resetBodyStream(r *fasthttp.Request) {
+	if body := loadBodyStream(r); body != nil {
+		body.setBody(r)
+	}
+}
+
+// bodyFile reads request bodies from a file, the file is split into chunks of
+// lines in chunk modes.
+type bodyFile struct {
+	file *os.File
+	size int64
+	mode string
+	// Start offsets of the chunks
+	chunks []int64
+	next   uint64
+}
+
+func newBodyFile(path string, mode string, chunkLines int) (*bodyFile, error) {
+	switch mode {
+	case "":
+		mode = bodyFileWhole
+	case bodyFileWhole, bodyFileSequentialChunk, bodyFileRandomChunk:
+	default:
+		return nil, fmt.Errorf("unsupported body_file_mode [%s]", mode)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f := &bodyFile{file: file, size: info.Size(), mode: mode}
+	if mode == bodyFileWhole {
+		return f, nil
+	}
+
+	if chunkLines <= 0 {
+		chunkLines = 1000
+	}
+	if f.chunks, err = indexChunks(io.NewSectionReader(file, 0, f.size), chunkLines); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if len(f.chunks) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("body_file [%s] is empty", path)
+	}
+	return f, nil
+}
+
+func (f *bodyFile) close() error {
+	return f.file.Close()
+}
+
+// indexChunks scans the offsets of every chunkLines lines.
+func indexChunks(r io.Reader, chunkLines int) ([]int64, error) {
+	var chunks []int64
+	reader := bufio.NewReaderSize(r, 1<<20)
+	var offset int64
+	lines := 0
+	lineStart := true
+	for {
+		data, err := reader.ReadSlice('\n')
+		if len(data) > 0 {
+			if lineStart {
+				if lines%chunkLines == 0 {
+					chunks = append(chunks, offset)
+				}
+				lines++
+			}
+			offset += int64(len(data))
+			lineStart = data[len(data)-1] == '\n'
+		}
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// chunk returns the section of the next body of the VU, the sequential chunks
+// start over after the last one.
+func (f *bodyFile) chunk(vu int) *fileSection {
+	var i int
+	switch f.mode {
+	case bodyFileWhole:
+		return &fileSection{file: f.file, length: f.size}
+	case bodyFileSequentialChunk:
+		i = int((atomic.AddUint64(&f.next, 1) - 1) % uint64(len(f.chunks)))
+	default:
+		i = vuRand(vu).Intn(len(f.chunks))
+	}
+	end := f.size
+	if i+1 < len(f.chunks) {
+		end = f.chunks[i+1]
+	}
+	return &fileSection{file: f.file, offset: f.chunks[i], length: end - f.chunks[i]}
+}
+
+// fileSection is a body read from the file as is.
+type fileSection struct {
+	file   *os.File
+	offset int64
+	length int64
+}
+
+func (s *fileSection) reader() *io.SectionReader {
+	return io.NewSectionReader(s.file, s.offset, s.length)
+}
+
+func (s *fileSection) setBody(req *fasthttp.Request) {
+	req.SetBodyStream(s.reader(), int(s.length))
+}
+
+func (s *fileSection) open() io.ReadCloser {
+	return io.NopCloser(s.reader())
+}
+
+// writeLines renders each line of the section with runtime variables, runtime
+// body line variables are refreshed for each line, values are escaped by the
+// escape mode. Lines are read and compiled one at a time, so that a large file
+// is never held in memory.
+func (s *fileSection) writeLines(w io.Writer, escape string, lineVariables *orderedVariables, runtimeVariables util.MapStr) error {
+	reader := bufio.NewReaderSize(s.reader(), 65536)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			tmpl, tmplErr := newTemplate(line)
+			if tmplErr != nil {
+				return tmplErr
+			}
+			writeBody(w, line, tmpl, newPlaceholderEscapes(line, escape, false), 1, lineVariables, runtimeVariables)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func writeBodyFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "body.ndjson")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readSection(t *testing.T, section *fileSection) string {
+	data, err := io.ReadAll(section.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBodyFileSequentialChunk(t *testing.T) {
+	path := writeBodyFile(t, "1\n2\n3\n4\n5")
+	f, err := newBodyFile(path, bodyFileSequentialChunk, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"1\n2\n", "3\n4\n", "5", "1\n2\n"} {
+		if chunk := readSection(t, f.chunk(0)); chunk != expected {
+			t.Errorf("expected chunk %q, got %q", expected, chunk)
+		}
+	}
+}
+
+func TestBodyFileRandomChunk(t *testing.T) {
+	path := writeBodyFile(t, "1\n2\n3\n")
+	f, err := newBodyFile(path, bodyFileRandomChunk, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if chunk := readSection(t, f.chunk(0)); chunk != "1\n" && chunk != "2\n" && chunk != "3\n" {
+			t.Errorf("unexpected chunk %q", chunk)
+		}
+	}
+}
+
+func TestBodyFileWhole(t *testing.T) {
+	data := string([]byte{0x00, 0xff, '\n', 0x01})
+	f, err := newBodyFile(writeBodyFile(t, data), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readSection(t, f.chunk(0)); body != data {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if _, err = newBodyFile(writeBodyFile(t, ""), bodyFileSequentialChunk, 0); err == nil {
+		t.Error("expected error of empty file")
+	}
+	if _, err = newBodyFile(writeBodyFile(t, data), "chunk", 0); err == nil {
+		t.Error("expected error of unsupported mode")
+	}
+}
+
+func TestIndexChunksLongLine(t *testing.T) {
+	long := strings.Repeat("x", 3<<20)
+	chunks, err := indexChunks(strings.NewReader(long+"\nshort\n"+long), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{0, int64(len(long)) + 1, int64(len(long)) + 7}
+	if len(chunks) != len(expected) || chunks[1] != expected[1] || chunks[2] != expected[2] {
+		t.Errorf("expected chunks %v, got %v", expected, chunks)
+	}
+}
+
+func TestFileSectionWriteLines(t *testing.T) {
+	path := writeBodyFile(t, `{"index":{"_id":"$[[id]]"}}`+"\n"+`{"user":"$[[user]]"}`+"\n")
+	f, err := newBodyFile(path, bodyFileWhole, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := &bytes.Buffer{}
+	if err = f.chunk(0).writeLines(buffer, escapeNone, nil, util.MapStr{"id": "1", "user": "medcl"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"index":{"_id":"1"}}` + "\n" + `{"user":"medcl"}` + "\n"; buffer.String() != expected {
+		t.Errorf("unexpected body %q", buffer.String())
+	}
+
+	buffer.Reset()
+	if err = f.chunk(0).writeLines(buffer, escapeNone, nil, util.MapStr{"id": "2", "user": "infini"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"index":{"_id":"2"}}` + "\n" + `{"user":"infini"}` + "\n"; buffer.String() != expected {
+		t.Errorf("unexpected body %q", buffer.String())
+	}
+
+	if err = f.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBodyFileTemplateAfterMultipart(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		bodies = append(bodies, string(body))
+		lock.Unlock()
+	}))
+	defer server.Close()
+	httpClient = &fasthttp.Client{}
+	defer func() { httpClient = nil }()
+
+	config := &LoaderConfig{
+		Requests: []RequestItem{
+			{Request: &Request{Method: "POST", Url: server.URL, Multipart: []MultipartPart{{Name: "user", Value: "multipart"}}}},
+			{Request: &Request{Method: "POST", Url: server.URL, BodyFile: writeBodyFile(t, `{"user":"$[[user]]"}`), BodyFileTemplate: true, ExecuteRepeatTimes: 2}},
+		},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer config.close()
+
+	globalCtx := util.MapStr{vuKey: 0, "user": "medcl"}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	loadStats := &LoadStats{MinRequestTime: time.Minute, StatusCode: map[int]int{}}
+	for i := range config.Requests {
+		item := &config.Requests[i]
+		if err := item.prepareRequest(config, globalCtx, req, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := doRequest(config, globalCtx, req, resp, item, loadStats, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loadBodyStream(req) != nil {
+		t.Error("expected no body stream of the templated body file")
+	}
+	if len(bodies) != 3 || !strings.Contains(bodies[0], "multipart") || bodies[1] != `{"user":"medcl"}` || bodies[2] != bodies[1] {
+		t.Errorf("unexpected bodies: %q", bodies)
+	}
+}
+
+func TestBodyFileTemplateError(t *testing.T) {
+	config := &LoaderConfig{
+		Requests: []RequestItem{
+			{Request: &Request{Method: "POST", Url: "http://localhost:9200/_bulk", BodyFile: writeBodyFile(t, `{"user":"$[[user"}`), BodyFileTemplate: true}},
+		},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer config.close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if err := config.Requests[0].prepareRequest(config, util.MapStr{}, req, 0); err == nil {
+		t.Error("expected error of the bad template on the body file line")
+	}
+}
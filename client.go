@@ -0,0 +1,292 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	protocolHTTP1 = "http1"
+	protocolH2    = "h2"
+	protocolH2C   = "h2c"
+)
+
+// requestDoer sends a prepared request and fills the response, it is
+// implemented by *fasthttp.Client for HTTP/1.1 and *http2Client for HTTP/2.
+type requestDoer interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+}
+
+// connStats counts the connections dialed by all clients.
+var connStats struct {
+	opened int64
+	closed int64
+	// Dial errors of running out of local ports or addresses
+	exhausted int64
+}
+
+// resetConnStats clears connStats before a run.
+func resetConnStats() {
+	atomic.StoreInt64(&connStats.opened, 0)
+	atomic.StoreInt64(&connStats.closed, 0)
+	atomic.StoreInt64(&connStats.exhausted, 0)
+}
+
+// countedConn reports its close to connStats, only the first Close is counted.
+type countedConn struct {
+	net.Conn
+	closed int32
+}
+
+func (c *countedConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&connStats.closed, 1)
+	}
+	return c.Conn.Close()
+}
+
+func dialConn(addr string) (net.Conn, error) {
+	return dialConnFrom(addr, localAddrs.next())
+}
+
+// dialConnFrom dials the address from the local IP, any if it is nil.
+func dialConnFrom(addr string, localIP net.IP) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	useProxy := connProxy != nil && connProxy.useProxy(addr)
+	if !useProxy && connResolver != nil {
+		if addr, err = connResolver.resolve(addr); err != nil {
+			return nil, err
+		}
+	}
+	if useProxy {
+		conn, err = connProxy.dial(addr, time.Duration(dialTimeout)*time.Second)
+	} else if localIP != nil {
+		conn, err = newLocalDialer(time.Duration(dialTimeout)*time.Second, localIP).Dial("tcp", addr)
+	} else if dialTimeout > 0 {
+		conn, err = fasthttp.DialTimeout(addr, time.Duration(dialTimeout)*time.Second)
+	} else {
+		conn, err = fasthttp.Dial(addr)
+	}
+	if err != nil {
+		if isPortExhausted(err) {
+			atomic.AddInt64(&connStats.exhausted, 1)
+		}
+		return nil, err
+	}
+	atomic.AddInt64(&connStats.opened, 1)
+	if !useProxy && connResolver != nil {
+		countConn(addr)
+	}
+	return &countedConn{Conn: conn}, nil
+}
+
+// dialUDP dials a UDP socket like dialConn, the address is resolved by
+// connResolver and bound to the next local address, proxies are skipped as
+// they only tunnel TCP.
+func dialUDP(addr string) (net.Conn, error) {
+	var err error
+	if connResolver != nil {
+		if addr, err = connResolver.resolve(addr); err != nil {
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(dialTimeout) * time.Second}
+	if localIP := localAddrs.next(); localIP != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: localIP}
+	}
+	conn, err := dialer.Dial("udp", addr)
+	if err != nil {
+		if isPortExhausted(err) {
+			atomic.AddInt64(&connStats.exhausted, 1)
+		}
+		return nil, err
+	}
+	atomic.AddInt64(&connStats.opened, 1)
+	if connResolver != nil {
+		countConn(addr)
+	}
+	return &countedConn{Conn: conn}, nil
+}
+
+// localAddrs rotates the source IPs of outgoing TCP connections if configured.
+var localAddrs *localAddrPool
+
+type localAddrPool struct {
+	counter uint32
+	ips     []net.IP
+	// Pin one address to each VU instead of rotating per connection
+	perVU bool
+}
+
+const (
+	localAddrRoundRobin = "round_robin"
+	localAddrPerVU      = "per_vu"
+)
+
+// maxLocalAddrs limits the number of IPs expanded from CIDRs.
+const maxLocalAddrs = 65536
+
+func newLocalAddrPool(addrs []string, strategy string) (*localAddrPool, error) {
+	pool := &localAddrPool{}
+	switch strategy {
+	case "", localAddrRoundRobin:
+	case localAddrPerVU:
+		pool.perVU = true
+	default:
+		return nil, fmt.Errorf("unsupported local_address_strategy [%s]", strategy)
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			pool.ips = append(pool.ips, ip)
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address [%s]", addr)
+		}
+		for ip = ip.Mask(ipNet.Mask); ipNet.Contains(ip); ip = nextIP(ip) {
+			if len(pool.ips) >= maxLocalAddrs {
+				return nil, fmt.Errorf("too many local addresses, max: %v", maxLocalAddrs)
+			}
+			pool.ips = append(pool.ips, ip)
+		}
+	}
+	return pool, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// next returns the local address of a new connection in turn, nil if no
+// address is configured.
+func (pool *localAddrPool) next() net.IP {
+	if pool == nil || len(pool.ips) == 0 {
+		return nil
+	}
+	i := int(atomic.AddUint32(&pool.counter, 1)-1) % len(pool.ips)
+	return pool.ips[i]
+}
+
+// of returns the local address pinned to the VU, the warm-up (-1) uses the
+// first one.
+func (pool *localAddrPool) of(vu int) net.IP {
+	if pool == nil || len(pool.ips) == 0 {
+		return nil
+	}
+	if vu < 0 {
+		vu = 0
+	}
+	return pool.ips[vu%len(pool.ips)]
+}
+
+// newDialer returns a TCP dialer with the next local address.
+func newDialer(timeout time.Duration) *net.Dialer {
+	return newLocalDialer(timeout, localAddrs.next())
+}
+
+func newLocalDialer(timeout time.Duration, localIP net.IP) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+	return dialer
+}
+
+// vuClientsKey is the key of the HTTP clients in the context of each VU if
+// local addresses are pinned by VU.
+const vuClientsKey = "_http_clients"
+
+// vuClients are the HTTP clients of a VU dialing from its own local address,
+// one for each TLS config, created on first use.
+type vuClients struct {
+	runnerConfig *RunnerConfig
+	tlsConfig    *tls.Config
+	dial         fasthttp.DialFunc
+	clients      map[*tls.Config]requestDoer
+}
+
+func newVUClients(runnerConfig *RunnerConfig, tlsConfig *tls.Config, vu int) *vuClients {
+	localIP := localAddrs.of(vu)
+	return &vuClients{
+		runnerConfig: runnerConfig,
+		tlsConfig:    tlsConfig,
+		dial: func(addr string) (net.Conn, error) {
+			return dialConnFrom(addr, localIP)
+		},
+		clients: map[*tls.Config]requestDoer{},
+	}
+}
+
+// vuClientsOf returns the HTTP clients in the context, nil if the shared
+// clients are used.
+func vuClientsOf(globalCtx util.MapStr) *vuClients {
+	if clients, ok := globalCtx[vuClientsKey].(*vuClients); ok {
+		return clients
+	}
+	return nil
+}
+
+// get returns the client of the TLS config, the default one if it is nil.
+func (c *vuClients) get(tlsConfig *tls.Config) requestDoer {
+	if tlsConfig == nil {
+		tlsConfig = c.tlsConfig
+	}
+	client, ok := c.clients[tlsConfig]
+	if !ok {
+		client = newHTTPClient(c.runnerConfig, 1, tlsConfig, c.dial)
+		c.clients[tlsConfig] = client
+	}
+	return client
+}
+
+// isPortExhausted checks if the error is caused by running out of local
+// ports or addresses.
+func isPortExhausted(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,98 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestLocalAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	remotes := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			remotes <- host
+			conn.Close()
+		}
+	}()
+
+	localAddrs, err = newLocalAddrPool([]string{"127.0.0.2", "127.0.0.4/31"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { localAddrs = nil }()
+	if len(localAddrs.ips) != 3 {
+		t.Fatalf("expected 3 local addresses, got %v", localAddrs.ips)
+	}
+
+	for i := 0; i < 4; i++ {
+		conn, err := dialConn(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	for _, expected := range []string{"127.0.0.2", "127.0.0.4", "127.0.0.5", "127.0.0.2"} {
+		if remote := <-remotes; remote != expected {
+			t.Errorf("expected connection from %v, got %v", expected, remote)
+		}
+	}
+
+	localAddrs.perVU = true
+	for _, vu := range []int{1, 1, 3, -1} {
+		clients := newVUClients(&RunnerConfig{}, nil, vu)
+		conn, err := clients.dial(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	for _, expected := range []string{"127.0.0.4", "127.0.0.4", "127.0.0.2", "127.0.0.2"} {
+		if remote := <-remotes; remote != expected {
+			t.Errorf("expected connection of VU from %v, got %v", expected, remote)
+		}
+	}
+
+	if _, err := newLocalAddrPool([]string{"10.0.0.0/8"}, ""); err == nil {
+		t.Error("expected error of too many local addresses")
+	}
+	if _, err := newLocalAddrPool([]string{"127.0.0.2"}, "random"); err == nil {
+		t.Error("expected error of unsupported local address strategy")
+	}
+	if !isPortExhausted(&net.OpError{Op: "dial", Err: fmt.Errorf("connect: %w", syscall.EADDRNOTAVAIL)}) {
+		t.Error("expected port exhaustion error")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	codecNone    = "none"
+	codecGzip    = "gzip"
+	codecDeflate = "deflate"
+	codecZstd    = "zstd"
+	codecSnappy  = "snappy"
+	codecBrotli  = "br"
+)
+
+// CompressionConfig compresses request bodies with the codec.
+type CompressionConfig struct {
+	// gzip, deflate, zstd, snappy, br or none
+	Codec string `config:"codec"`
+	// Codec specific level, default: 0 (default level of the codec)
+	Level int `config:"level"`
+	// Accept-Encoding header of requests, default: the codec, set to none to
+	// skip the header
+	AcceptEncoding string `config:"accept_encoding"`
+
+	encoders sync.Pool
+}
+
+// encoder is implemented by the writers of all codecs.
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressionStats counts the request bodies compressed by all threads.
+var compressionStats struct {
+	requests     int64
+	uncompressed int64
+	compressed   int64
+	nanos        int64
+}
+
+// resetCompressionStats clears compressionStats before a run.
+func resetCompressionStats() {
+	atomic.StoreInt64(&compressionStats.requests, 0)
+	atomic.StoreInt64(&compressionStats.uncompressed, 0)
+	atomic.StoreInt64(&compressionStats.compressed, 0)
+	atomic.StoreInt64(&compressionStats.nanos, 0)
+}
+
+// defaultCompression is used by `-compress`.
+var defaultCompression = &CompressionConfig{Codec: codecGzip, Level: fasthttp.CompressBestCompression, AcceptEncoding: codecGzip}
+
+var bodyBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+func (c *CompressionConfig) init() error {
+	if c.Codec == "" {
+		c.Codec = codecGzip
+	}
+	if c.AcceptEncoding == "" {
+		c.AcceptEncoding = c.Codec
+	}
+	if c.Codec == codecNone {
+		return nil
+	}
+	if _, err := c.newEncoder(io.Discard); err != nil {
+		return fmt.Errorf("invalid compression [%s] of level [%v]: %v", c.Codec, c.Level, err)
+	}
+	return nil
+}
+
+func (c *CompressionConfig) newEncoder(w io.Writer) (encoder, error) {
+	switch c.Codec {
+	case codecGzip:
+		if c.Level == 0 {
+			return gzip.NewWriter(w), nil
+		}
+		return gzip.NewWriterLevel(w, c.Level)
+	case codecDeflate:
+		if c.Level == 0 {
+			return zlib.NewWriter(w), nil
+		}
+		return zlib.NewWriterLevel(w, c.Level)
+	case codecZstd:
+		if c.Level == 0 {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+	case codecSnappy:
+		return s2.NewWriter(w, s2.WriterSnappyCompat()), nil
+	case codecBrotli:
+		if c.Level == 0 {
+			return brotli.NewWriter(w), nil
+		}
+		if c.Level < brotli.BestSpeed || c.Level > brotli.BestCompression {
+			return nil, fmt.Errorf("level out of range")
+		}
+		return brotli.NewWriterLevel(w, c.Level), nil
+	}
+	return nil, fmt.Errorf("unsupported codec")
+}
+
+// compress writes the compressed data to w, encoders are reused.
+func (c *CompressionConfig) compress(w io.Writer, data []byte) error {
+	start := time.Now()
+	counter := &countingWriter{Writer: w}
+	var enc encoder
+	if v := c.encoders.Get(); v != nil {
+		enc = v.(encoder)
+		enc.Reset(counter)
+	} else {
+		var err error
+		if enc, err = c.newEncoder(counter); err != nil {
+			return err
+		}
+	}
+	_, err := enc.Write(data)
+	if closeErr := enc.Close(); err == nil {
+		err = closeErr
+	}
+	c.encoders.Put(enc)
+
+	atomic.AddInt64(&compressionStats.requests, 1)
+	atomic.AddInt64(&compressionStats.uncompressed, int64(len(data)))
+	atomic.AddInt64(&compressionStats.compressed, counter.n)
+	atomic.AddInt64(&compressionStats.nanos, int64(time.Since(start)))
+	return err
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// compression returns the compression of the request, or the default of the
+// runner, nil if not configured.
+func (req *Request) compression(config *LoaderConfig) *CompressionConfig {
+	if req.Compression != nil {
+		return req.Compression
+	}
+	if config.RunnerConfig.Compression != nil {
+		return config.RunnerConfig.Compression
+	}
+	if compress {
+		return defaultCompression
+	}
+	return nil
+}
+
+// decodeBody decodes the response body by the Content-Encoding header,
+// encodings are decoded in reverse order. The raw body is returned if it's not
+// encoded.
+func decodeBody(resp *fasthttp.Response) ([]byte, error) {
+	body := resp.GetRawBody()
+	contentEncoding := strings.TrimSpace(string(resp.Header.Peek(fasthttp.HeaderContentEncoding)))
+	if contentEncoding == "" || len(body) == 0 {
+		return body, nil
+	}
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		codec := strings.ToLower(strings.TrimSpace(encodings[i]))
+		switch codec {
+		case "identity", "":
+			continue
+		case codecGzip, "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case codecDeflate:
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+		case codecZstd:
+			var decoder *zstd.Decoder
+			if decoder, err = zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1)); err == nil {
+				defer decoder.Close()
+				reader = decoder
+			}
+		case codecSnappy:
+			reader = s2.NewReader(bytes.NewReader(body))
+		case codecBrotli:
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding [%s]", codec)
+		}
+		if err == nil {
+			body, err = io.ReadAll(reader)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode [%s] body: %v", codec, err)
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat(`{"index":{"_index":"test"}}`+"\n", 100))
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		codecGzip: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		codecDeflate: func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		codecZstd: func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+		codecSnappy: func(r io.Reader) (io.Reader, error) {
+			return snappy.NewReader(r), nil
+		},
+		codecBrotli: func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+
+	for codec, decode := range decoders {
+		c := &CompressionConfig{Codec: codec}
+		if err := c.init(); err != nil {
+			t.Fatal(err)
+		}
+		if c.AcceptEncoding != codec {
+			t.Errorf("unexpected accept encoding of %s: %s", codec, c.AcceptEncoding)
+		}
+		// the second round reuses the pooled encoder
+		for i := 0; i < 2; i++ {
+			requests := atomic.LoadInt64(&compressionStats.requests)
+			compressed := atomic.LoadInt64(&compressionStats.compressed)
+
+			buffer := &bytes.Buffer{}
+			if err := c.compress(buffer, data); err != nil {
+				t.Fatal(err)
+			}
+			if buffer.Len() >= len(data) {
+				t.Errorf("%s is not compressed: %v bytes", codec, buffer.Len())
+			}
+			if atomic.LoadInt64(&compressionStats.requests) != requests+1 || atomic.LoadInt64(&compressionStats.compressed) != compressed+int64(buffer.Len()) {
+				t.Errorf("unexpected stats of %s", codec)
+			}
+
+			reader, err := decode(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("unexpected data of %s: %s", codec, decompressed)
+			}
+		}
+	}
+}
+
+func TestCompressionInit(t *testing.T) {
+	c := &CompressionConfig{}
+	if err := c.init(); err != nil || c.Codec != codecGzip || c.AcceptEncoding != codecGzip {
+		t.Errorf("unexpected default: %+v, %v", c, err)
+	}
+	c = &CompressionConfig{Codec: codecNone, AcceptEncoding: "gzip, br"}
+	if err := c.init(); err != nil || c.AcceptEncoding != "gzip, br" {
+		t.Errorf("unexpected none: %+v, %v", c, err)
+	}
+	if err := (&CompressionConfig{Codec: "lz4"}).init(); err == nil {
+		t.Error("expected error of unsupported codec")
+	}
+	if err := (&CompressionConfig{Codec: codecGzip, Level: 20}).init(); err == nil {
+		t.Error("expected error of invalid level")
+	}
+	if err := (&CompressionConfig{Codec: codecBrotli, Level: 12}).init(); err == nil {
+		t.Error("expected error of invalid level")
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	data := []byte(`{"acknowledged":true}`)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	for _, codec := range []string{codecGzip, codecDeflate, codecZstd, codecSnappy, codecBrotli} {
+		c := &CompressionConfig{Codec: codec}
+		if err := c.init(); err != nil {
+			t.Fatal(err)
+		}
+		buffer := &bytes.Buffer{}
+		if err := c.compress(buffer, data); err != nil {
+			t.Fatal(err)
+		}
+		resp.Reset()
+		resp.Header.Set(fasthttp.HeaderContentEncoding, codec)
+		resp.SetBody(buffer.Bytes())
+
+		body, err := decodeBody(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := buildCtx(resp, body, 0)
+		if v, _ := event.GetValue("_ctx.response.body_json.acknowledged"); v != true {
+			t.Errorf("unexpected body of %s: %s", codec, body)
+		}
+		if v, _ := event.GetValue("_ctx.response.raw_body_length"); v != buffer.Len() {
+			t.Errorf("unexpected raw body length of %s: %v", codec, v)
+		}
+	}
+
+	resp.Reset()
+	resp.Header.Set(fasthttp.HeaderContentEncoding, "lz4")
+	resp.SetBody(data)
+	if _, err := decodeBody(resp); err == nil {
+		t.Error("expected error of unsupported content encoding")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// cookieJar keeps the cookies of a virtual user, domain, path and expiry of
+// cookies are handled by net/http/cookiejar as RFC 6265.
+type cookieJar struct {
+	jar *cookiejar.Jar
+}
+
+// cookieJarKey is the key of the cookie jar in the context of each VU.
+const cookieJarKey = "_cookie_jar"
+
+// cookieJarOf returns the cookie jar in the context, nil if cookies are not
+// kept.
+func cookieJarOf(globalCtx util.MapStr) *cookieJar {
+	if jar, ok := globalCtx[cookieJarKey].(*cookieJar); ok {
+		return jar
+	}
+	return nil
+}
+
+func newCookieJar() *cookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{jar: jar}
+}
+
+// apply adds the cookies matching the request url.
+func (j *cookieJar) apply(req *fasthttp.Request) {
+	u, err := url.Parse(req.URI().String())
+	if err != nil {
+		return
+	}
+	for _, cookie := range j.jar.Cookies(u) {
+		req.Header.SetCookie(cookie.Name, cookie.Value)
+	}
+}
+
+// update stores the cookies set by the response, and exposes the cookies of
+// the request url as `cookie.NAME` in globalCtx.
+func (j *cookieJar) update(globalCtx util.MapStr, req *fasthttp.Request, resp *fasthttp.Response) {
+	u, err := url.Parse(req.URI().String())
+	if err != nil {
+		return
+	}
+	j.jar.SetCookies(u, responseCookies(resp))
+
+	cookies := util.MapStr{}
+	for _, cookie := range j.jar.Cookies(u) {
+		cookies[cookie.Name] = cookie.Value
+	}
+	globalCtx["cookie"] = cookies
+}
+
+// responseCookies parses the Set-Cookie headers of the response.
+func responseCookies(resp *fasthttp.Response) []*http.Cookie {
+	header := http.Header{}
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		header.Add("Set-Cookie", string(value))
+	})
+	return (&http.Response{Header: header}).Cookies()
+}
@@ -0,0 +1,92 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestCookieJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: "admin", Value: "1", Path: "/admin"})
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Path: "/", MaxAge: -1})
+		default:
+			if cookie, err := r.Cookie("sid"); err != nil || cookie.Value != "abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			if _, err := r.Cookie("admin"); err == nil {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := &fasthttp.Client{}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	jar := newCookieJar()
+	globalCtx := util.MapStr{}
+	do := func(path string) int {
+		req.Reset()
+		req.SetRequestURI(server.URL + path)
+		jar.apply(req)
+		if err := client.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		jar.update(globalCtx, req, resp)
+		return resp.StatusCode()
+	}
+
+	if status := do("/me"); status != http.StatusUnauthorized {
+		t.Errorf("expected 401 before login, got %v", status)
+	}
+	do("/login")
+	event := buildCtx(resp, nil, 0)
+	if v, _ := event.GetValue("_ctx.response.cookies.sid"); v != "abc" {
+		t.Errorf("unexpected response cookies: %v", event)
+	}
+	if status := do("/me"); status != http.StatusOK {
+		t.Errorf("expected 200 after login, got %v", status)
+	}
+	if v := GetVariable(globalCtx, "cookie.sid"); v != "abc" {
+		t.Errorf("unexpected cookie variable: %v", v)
+	}
+
+	do("/logout")
+	if _, err := globalCtx.GetValue("cookie.sid"); err == nil {
+		t.Error("expected cookie to be removed after logout")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	csvOrderSequential = "sequential"
+	csvOrderRandom     = "random"
+	csvOrderShuffle    = "shuffle"
+
+	csvBindIteration = "iteration"
+	csvBindVU        = "vu"
+
+	csvRecycle = "recycle"
+	csvStop    = "stop"
+	csvStopVU  = "stop_vu"
+)
+
+// csvSource selects rows of a CSV/TSV file, columns of a row are accessed by
+// `$[[name.column]]`.
+type csvSource struct {
+	name      string
+	header    []string
+	rows      [][]string
+	order     string
+	bind      string
+	onExhaust string
+
+	lock     sync.Mutex
+	next     int
+	rand     *rand.Rand
+	consumed int64
+}
+
+func newCSVSource(x *Variable) (*csvSource, error) {
+	s := &csvSource{name: x.Name, order: x.Order, bind: x.Bind, onExhaust: x.OnExhaust}
+	switch s.order {
+	case "":
+		s.order = csvOrderSequential
+	case csvOrderSequential, csvOrderRandom, csvOrderShuffle:
+	default:
+		return nil, fmt.Errorf("unsupported order [%s]", s.order)
+	}
+	switch s.bind {
+	case "":
+		s.bind = csvBindIteration
+	case csvBindIteration, csvBindVU:
+	default:
+		return nil, fmt.Errorf("unsupported bind [%s]", s.bind)
+	}
+	switch s.onExhaust {
+	case "":
+		s.onExhaust = csvRecycle
+	case csvRecycle, csvStop, csvStopVU:
+	default:
+		return nil, fmt.Errorf("unsupported on_exhaust [%s]", s.onExhaust)
+	}
+
+	delimiter := x.Delimiter
+	if delimiter == "" {
+		delimiter = ","
+		if strings.HasSuffix(strings.ToLower(x.Path), ".tsv") {
+			delimiter = "\t"
+		}
+	}
+	if delimiter == `\t` {
+		delimiter = "\t"
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return nil, fmt.Errorf("delimiter must be a single character")
+	}
+
+	f, err := os.Open(x.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := readCSV(f, []rune(delimiter)[0], x.Quoting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read [%s]: %v", x.Path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("[%s] has no rows after the header", x.Path)
+	}
+	for i, column := range records[0] {
+		records[0][i] = util.TrimSpaces(column)
+	}
+	s.header, s.rows = records[0], records[1:]
+
+	s.rand = rand.New(rand.NewSource(variableSeed(x)))
+	if s.order == csvOrderShuffle {
+		s.shuffle()
+	}
+	log.Debugf("csv variable [%s], columns: %v, num of rows: %v", s.name, s.header, len(s.rows))
+	return s, nil
+}
+
+// readCSV reads all records, fields are split by the delimiter only if
+// quoting is none.
+func readCSV(r io.Reader, delimiter rune, quoting string) ([][]string, error) {
+	switch quoting {
+	case "", "rfc4180", "lazy":
+		reader := csv.NewReader(r)
+		reader.Comma = delimiter
+		reader.LazyQuotes = quoting == "lazy"
+		reader.FieldsPerRecord = -1
+		return reader.ReadAll()
+	case "none":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var records [][]string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if line == "" {
+				continue
+			}
+			records = append(records, strings.Split(line, string(delimiter)))
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("unsupported quoting [%s]", quoting)
+}
+
+func (s *csvSource) shuffle() {
+	s.rand.Shuffle(len(s.rows), func(i, j int) {
+		s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+	})
+}
+
+// nextRow returns the columns of the next row of the VU, false if sequential
+// rows run out and not recycled.
+func (s *csvSource) nextRow(vu int) (util.MapStr, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var row []string
+	if s.order == csvOrderRandom {
+		row = s.rows[vuRand(vu).Intn(len(s.rows))]
+	} else {
+		if s.next >= len(s.rows) {
+			if s.onExhaust != csvRecycle {
+				return nil, false
+			}
+			s.next = 0
+			if s.order == csvOrderShuffle {
+				s.shuffle()
+			}
+		}
+		row = s.rows[s.next]
+		s.next++
+	}
+	atomic.AddInt64(&s.consumed, 1)
+	return s.toMap(row), true
+}
+
+func (s *csvSource) toMap(row []string) util.MapStr {
+	columns := util.MapStr{}
+	for i, column := range s.header {
+		if i < len(row) {
+			columns[column] = row[i]
+		} else {
+			columns[column] = ""
+		}
+	}
+	return columns
+}
+
+// csvSources returns the csv variables.
+func csvSources() []*csvSource {
+	var sources []*csvSource
+	for _, name := range variableNames {
+		if v := variables[name]; v.csv != nil {
+			sources = append(sources, v.csv)
+		}
+	}
+	return sources
+}
+
+// bindRows puts the next rows of sources into globalCtx at the beginning of
+// an iteration, false if the VU should stop.
+func (cfg *LoadGenerator) bindRows(sources []*csvSource, globalCtx util.MapStr, firstRound bool) bool {
+	for _, s := range sources {
+		if s.bind == csvBindVU && !firstRound {
+			continue
+		}
+		row, ok := s.nextRow(vuOf(globalCtx))
+		if !ok {
+			log.Infof("rows of csv variable [%s] ran out", s.name)
+			if s.onExhaust == csvStop {
+				cfg.Stop()
+			}
+			return false
+		}
+		globalCtx[s.name] = row
+	}
+	return true
+}
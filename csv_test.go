@@ -0,0 +1,113 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"infini.sh/framework/core/util"
+)
+
+func writeCSV(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCSVSource(t *testing.T) {
+	path := writeCSV(t, "users.csv", "name, password\nmedcl,\"a,b\"\nelastic,\"say \"\"hi\"\"\"\n")
+	s, err := newCSVSource(&Variable{Name: "users", Path: path, OnExhaust: csvStopVU})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range [][]string{{"medcl", "a,b"}, {"elastic", `say "hi"`}} {
+		row, ok := s.nextRow(0)
+		if !ok || row["name"] != expected[0] || row["password"] != expected[1] {
+			t.Errorf("unexpected row: %v, %v", row, ok)
+		}
+	}
+	if _, ok := s.nextRow(0); ok {
+		t.Error("expected rows to run out")
+	}
+
+	path = writeCSV(t, "users.tsv", "name\tpassword\nmedcl\t\"a\nelastic\n")
+	s, err = newCSVSource(&Variable{Name: "users", Path: path, Quoting: "none", Order: csvOrderShuffle, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		row, ok := s.nextRow(0)
+		if !ok {
+			t.Fatal("expected rows to be recycled")
+		}
+		seen[row["name"].(string)+":"+row["password"].(string)]++
+	}
+	if seen[`medcl:"a`] != 2 || seen["elastic:"] != 2 {
+		t.Errorf("unexpected rows: %v", seen)
+	}
+
+	for _, x := range []Variable{
+		{Path: path, Order: "reverse"},
+		{Path: path, Delimiter: ";;"},
+		{Path: writeCSV(t, "empty.csv", "name\n")},
+	} {
+		if _, err = newCSVSource(&x); err == nil {
+			t.Errorf("expected error of %+v", x)
+		}
+	}
+}
+
+func TestBindRows(t *testing.T) {
+	config := &LoaderConfig{Variable: []Variable{
+		{Name: "users", Type: "csv", Path: writeCSV(t, "users.csv", "name,password\nmedcl,123\nelastic,456\n"), OnExhaust: csvStop},
+		{Name: "tenant", Type: "csv", Path: writeCSV(t, "tenants.csv", "id\nt1\nt2\n"), Bind: csvBindVU},
+	}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &LoadGenerator{}
+	sources := csvSources()
+	globalCtx := util.MapStr{}
+
+	if !cfg.bindRows(sources, globalCtx, true) {
+		t.Fatal("expected rows")
+	}
+	if GetVariable(globalCtx, "users.name") != "medcl" || GetVariable(globalCtx, "users.password") != "123" || GetVariable(globalCtx, "tenant.id") != "t1" {
+		t.Errorf("unexpected context: %v", globalCtx)
+	}
+	if !cfg.bindRows(sources, globalCtx, false) {
+		t.Fatal("expected rows")
+	}
+	if GetVariable(globalCtx, "users.name") != "elastic" || GetVariable(globalCtx, "users.password") != "456" || GetVariable(globalCtx, "tenant.id") != "t1" {
+		t.Errorf("unexpected context: %v", globalCtx)
+	}
+	if cfg.bindRows(sources, globalCtx, false) || cfg.interrupted == 0 {
+		t.Error("expected the run to stop")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	orderRandom      = "random"
+	orderSequential  = "sequential"
+	orderShuffleOnce = "shuffle_once"
+
+	exhaustRecycle = "recycle"
+	exhaustStop    = "stop"
+)
+
+// vuKey is the key of the VU number in the context of each VU, values of
+// cursors are iterated by each VU separately. The warm-up uses -1.
+const vuKey = "_vu"
+
+// listCursor iterates the values of a file or list variable in order, either
+// shared by all VUs or separately by each VU.
+type listCursor struct {
+	name   string
+	values []string
+	unique bool
+	stop   bool
+
+	lock      sync.Mutex
+	shared    int
+	next      map[int]int
+	exhausted map[int]bool
+	consumed  int64
+}
+
+func newListCursor(x *Variable, values []string) (*listCursor, error) {
+	switch x.Order {
+	case orderSequential, orderShuffleOnce:
+	default:
+		return nil, fmt.Errorf("unsupported order [%s]", x.Order)
+	}
+	if x.Distribution != "" && x.Distribution != "uniform" {
+		return nil, fmt.Errorf("distribution can't be used with order [%s]", x.Order)
+	}
+	c := &listCursor{name: x.Name, unique: x.UniqueAcrossGoroutines, next: map[int]int{}, exhausted: map[int]bool{}}
+	switch x.OnExhaust {
+	case "", exhaustRecycle:
+	case exhaustStop:
+		c.stop = true
+	default:
+		return nil, fmt.Errorf("unsupported on_exhaust [%s]", x.OnExhaust)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no values")
+	}
+
+	c.values = values
+	if x.Order == orderShuffleOnce {
+		seed := variableSeed(x)
+		c.values = make([]string, len(values))
+		copy(c.values, values)
+		rand.New(rand.NewSource(seed)).Shuffle(len(c.values), func(i, j int) {
+			c.values[i], c.values[j] = c.values[j], c.values[i]
+		})
+	}
+	return c, nil
+}
+
+// value returns the next value of the VU, an empty string if the values are
+// exhausted and not recycled. The warm-up gets the first value without
+// consuming it.
+func (c *listCursor) value(vu int) string {
+	if vu < 0 {
+		return c.values[0]
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	offset := c.next[vu]
+	if c.unique {
+		offset = c.shared
+	}
+	if offset >= len(c.values) {
+		if c.stop {
+			c.exhausted[vu] = true
+			return ""
+		}
+		offset = 0
+	}
+	if c.unique {
+		c.shared = offset + 1
+	} else {
+		c.next[vu] = offset + 1
+	}
+	atomic.AddInt64(&c.consumed, 1)
+	return c.values[offset]
+}
+
+func (c *listCursor) exhaustedBy(vu int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.exhausted[vu]
+}
+
+// vuOf returns the VU number in the context.
+func vuOf(runtimeKV util.MapStr) int {
+	if vu, ok := runtimeKV[vuKey].(int); ok {
+		return vu
+	}
+	return 0
+}
+
+// listCursors returns the cursors of stopping variables.
+func listCursors() []*listCursor {
+	var cursors []*listCursor
+	for _, name := range variableNames {
+		if v := variables[name]; v.cursor != nil && v.cursor.stop {
+			cursors = append(cursors, v.cursor)
+		}
+	}
+	return cursors
+}
+
+// cursorsExhausted checks if the VU got any exhausted value.
+func cursorsExhausted(cursors []*listCursor, vu int) bool {
+	for _, c := range cursors {
+		if c.exhaustedBy(vu) {
+			log.Infof("values of variable [%s] are exhausted", c.name)
+			return true
+		}
+	}
+	return false
+}
+
+// exhaustedIn checks if the VU of the context got any exhausted value, items
+// rendered by doItem check it before sending.
+func exhaustedIn(globalCtx util.MapStr) bool {
+	return cursorsExhausted(listCursors(), vuOf(globalCtx))
+}
+
+// printVariableStats prints the consumed values of ordered variables and csv
+// rows.
+func printVariableStats() {
+	var names []string
+	for name, v := range variables {
+		if v.cursor != nil || v.csv != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	fmt.Println("\n[Variable Metrics]")
+	for _, name := range names {
+		v := variables[name]
+		var consumed int64
+		var total int
+		if v.cursor != nil {
+			consumed, total = atomic.LoadInt64(&v.cursor.consumed), len(v.cursor.values)
+		} else {
+			consumed, total = atomic.LoadInt64(&v.csv.consumed), len(v.csv.rows)
+		}
+		fmt.Printf("%s:\t\t%v consumed of %v\n", name, consumed, total)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"infini.sh/framework/core/util"
+)
+
+func TestListCursor(t *testing.T) {
+	values := []string{"a", "b", "c"}
+	c, err := newListCursor(&Variable{Order: orderSequential}, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := c.value(-1); v != "a" {
+		t.Errorf("unexpected value of warm-up: %s", v)
+	}
+	var vu0, vu1 []string
+	for i := 0; i < 4; i++ {
+		vu0 = append(vu0, c.value(0))
+		vu1 = append(vu1, c.value(1))
+	}
+	if strings.Join(vu0, "") != "abca" || strings.Join(vu1, "") != "abca" || c.consumed != 8 {
+		t.Errorf("unexpected values: %v, %v, consumed: %v", vu0, vu1, c.consumed)
+	}
+
+	c, _ = newListCursor(&Variable{Order: orderShuffleOnce, UniqueAcrossGoroutines: true, OnExhaust: exhaustStop, Seed: 1}, values)
+	var seen []string
+	for i := 0; i < 3; i++ {
+		seen = append(seen, c.value(i%2))
+	}
+	if c.exhaustedBy(0) || c.exhaustedBy(1) {
+		t.Error("unexpected exhaustion")
+	}
+	if v := c.value(1); v != "" || !c.exhaustedBy(1) || c.exhaustedBy(0) {
+		t.Errorf("expected exhaustion of vu 1, got: %s", v)
+	}
+	if values[0] != "a" {
+		t.Error("values should not be shuffled in place")
+	}
+	sort.Strings(seen)
+	if strings.Join(seen, "") != "abc" {
+		t.Errorf("expected each value once: %v", seen)
+	}
+
+	for _, x := range []Variable{
+		{Order: "reverse"},
+		{Order: orderSequential, OnExhaust: "wait"},
+		{Order: orderSequential, Distribution: "zipf"},
+	} {
+		if _, err = newListCursor(&x, values); err == nil {
+			t.Errorf("expected error of %+v", x)
+		}
+	}
+}
+
+func TestOrderedVariable(t *testing.T) {
+	config := &LoaderConfig{Variable: []Variable{
+		{Name: "id", Type: "list", Data: []string{"1", "2"}, Order: orderSequential, OnExhaust: exhaustStop},
+	}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cursors := listCursors()
+	ctx := util.MapStr{vuKey: 3}
+	if v1, v2 := GetVariable(ctx, "id"), GetVariable(ctx, "id"); v1 != "1" || v2 != "2" {
+		t.Errorf("unexpected values: %s, %s", v1, v2)
+	}
+	if cursorsExhausted(cursors, 3) {
+		t.Error("unexpected exhaustion")
+	}
+	GetVariable(ctx, "id")
+	if !cursorsExhausted(cursors, 3) || cursorsExhausted(cursors, 0) {
+		t.Error("expected exhaustion of vu 3")
+	}
+}
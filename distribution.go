@@ -0,0 +1,232 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// distributionTypes are the variable types generated by distribution.
+var distributionTypes = map[string]bool{
+	"zipf":        true,
+	"normal":      true,
+	"log_normal":  true,
+	"exponential": true,
+	"pareto":      true,
+	"hotspot":     true,
+}
+
+// distribution samples skewed numbers, values of the same seed are sampled in
+// the same sequence.
+type distribution struct {
+	typ  string
+	imax uint64
+
+	s, v        float64
+	mean        float64
+	stddev      float64
+	mu, sigma   float64
+	rate        float64
+	alpha, xm   float64
+	hotRate     float64
+	hotFraction float64
+
+	from, to  uint64
+	precision int
+
+	variable Variable
+	seed     int64
+	lock     sync.Mutex
+	rand     *rand.Rand
+	zipf     *rand.Zipf
+	vus      map[int]*distribution
+}
+
+// newDistribution creates the distribution of the variable, discrete values
+// of zipf and hotspot are sampled in [0, imax].
+func newDistribution(typ string, x *Variable, imax uint64) (*distribution, error) {
+	seed := variableSeed(x)
+	d := &distribution{
+		typ:         typ,
+		imax:        imax,
+		s:           x.S,
+		v:           x.V,
+		mean:        x.Mean,
+		stddev:      x.Stddev,
+		mu:          x.Mu,
+		sigma:       x.Sigma,
+		rate:        x.Rate,
+		alpha:       x.Alpha,
+		xm:          x.Xm,
+		hotRate:     x.HotRate,
+		hotFraction: x.HotFraction,
+		from:        x.From,
+		to:          x.To,
+		precision:   x.Precision,
+		variable:    *x,
+		seed:        seed,
+		rand:        rand.New(rand.NewSource(seed)),
+		vus:         map[int]*distribution{},
+	}
+
+	switch typ {
+	case "zipf":
+		if d.s == 0 {
+			d.s = 1.1
+		}
+		if d.v == 0 {
+			d.v = 1
+		}
+		if d.s <= 1 || d.v < 1 {
+			return nil, fmt.Errorf("zipf requires s > 1 and v >= 1")
+		}
+		d.zipf = rand.NewZipf(d.rand, d.s, d.v, imax)
+	case "normal":
+		if d.stddev == 0 {
+			d.stddev = 1
+		}
+	case "log_normal":
+		if d.sigma == 0 {
+			d.sigma = 1
+		}
+	case "exponential":
+		if d.rate == 0 {
+			d.rate = 1
+		}
+		if d.rate < 0 {
+			return nil, fmt.Errorf("exponential requires rate > 0")
+		}
+	case "pareto":
+		if d.alpha == 0 {
+			d.alpha = 1.16
+		}
+		if d.xm == 0 {
+			d.xm = 1
+		}
+		if d.alpha < 0 || d.xm < 0 {
+			return nil, fmt.Errorf("pareto requires alpha > 0 and xm > 0")
+		}
+	case "hotspot":
+		if d.hotRate == 0 {
+			d.hotRate = 0.8
+		}
+		if d.hotFraction == 0 {
+			d.hotFraction = 0.2
+		}
+		if d.hotRate < 0 || d.hotRate > 1 || d.hotFraction < 0 || d.hotFraction > 1 {
+			return nil, fmt.Errorf("hotspot requires hot_rate and hot_fraction in [0, 1]")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported distribution [%s]", typ)
+	}
+	return d, nil
+}
+
+// forVU returns the distribution of the VU, which is seeded by the seed and
+// the VU, so numbers of each VU are sampled in the same sequence.
+func (d *distribution) forVU(vu int) *distribution {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	v, ok := d.vus[vu]
+	if !ok {
+		x := d.variable
+		x.Seed = mixSeed(d.seed, uint64(int64(vu)))
+		// the parameters are validated already
+		v, _ = newDistribution(d.typ, &x, d.imax)
+		d.vus[vu] = v
+	}
+	return v
+}
+
+// sample returns the next number, the lock must be held.
+func (d *distribution) sample() float64 {
+	switch d.typ {
+	case "zipf":
+		return float64(d.zipf.Uint64())
+	case "normal":
+		return d.rand.NormFloat64()*d.stddev + d.mean
+	case "log_normal":
+		return math.Exp(d.rand.NormFloat64()*d.sigma + d.mu)
+	case "exponential":
+		return d.rand.ExpFloat64() / d.rate
+	case "pareto":
+		return d.xm / math.Pow(1-d.rand.Float64(), 1/d.alpha)
+	case "hotspot":
+		n := d.imax + 1
+		hot := uint64(math.Ceil(float64(n) * d.hotFraction))
+		if hot < 1 {
+			hot = 1
+		}
+		if hot >= n || d.rand.Float64() < d.hotRate {
+			return float64(d.rand.Int63n(int64(hot)))
+		}
+		return float64(hot + uint64(d.rand.Int63n(int64(n-hot))))
+	}
+	return 0
+}
+
+// value returns the next number of the variable, discrete values start from
+// `from`, others are clamped to `from` and `to` if `to` is set.
+func (d *distribution) value() string {
+	d.lock.Lock()
+	v := d.sample()
+	d.lock.Unlock()
+
+	if d.typ == "zipf" || d.typ == "hotspot" {
+		return strconv.FormatUint(d.from+uint64(v), 10)
+	}
+	if d.to > d.from {
+		v = math.Max(float64(d.from), math.Min(float64(d.to), v))
+	}
+	if d.precision > 0 {
+		return strconv.FormatFloat(v, 'f', d.precision, 64)
+	}
+	return strconv.FormatInt(int64(math.Round(v)), 10)
+}
+
+// index returns the offset of the next value in n values, continuous values
+// are floored and clamped to the range.
+func (d *distribution) index(n int) int {
+	d.lock.Lock()
+	v := d.sample()
+	d.lock.Unlock()
+
+	i := int(math.Floor(v))
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
@@ -0,0 +1,131 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDistributionSeed(t *testing.T) {
+	for typ := range distributionTypes {
+		x := &Variable{Seed: 42, From: 10, To: 1000}
+		a, err := newDistribution(typ, x, x.To-x.From)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := newDistribution(typ, x, x.To-x.From)
+		for i := 0; i < 10; i++ {
+			if v1, v2 := a.value(), b.value(); v1 != v2 {
+				t.Errorf("[%s] values of the same seed differ: %s, %s", typ, v1, v2)
+			}
+		}
+	}
+}
+
+func TestDistributionValues(t *testing.T) {
+	// 80% of hits on the first 20% keys
+	x := &Variable{Seed: 1, From: 100, To: 199}
+	d, _ := newDistribution("hotspot", x, x.To-x.From)
+	hot := 0
+	for i := 0; i < 10000; i++ {
+		v, _ := strconv.Atoi(d.value())
+		if v < 100 || v > 199 {
+			t.Fatalf("value out of range: %v", v)
+		}
+		if v < 120 {
+			hot++
+		}
+	}
+	if hot < 7800 || hot > 8200 {
+		t.Errorf("unexpected hot hits: %v", hot)
+	}
+
+	x = &Variable{Seed: 1, Mean: 50, Stddev: 10, Precision: 2}
+	d, _ = newDistribution("normal", x, 0)
+	sum := 0.0
+	for i := 0; i < 10000; i++ {
+		s := d.value()
+		if dot := strings.IndexByte(s, '.'); dot < 0 || len(s)-dot-1 != 2 {
+			t.Fatalf("unexpected precision: %s", s)
+		}
+		v, _ := strconv.ParseFloat(s, 64)
+		sum += v
+	}
+	if mean := sum / 10000; math.Abs(mean-50) > 1 {
+		t.Errorf("unexpected mean: %v", mean)
+	}
+
+	x = &Variable{Seed: 1, Rate: 0.001, From: 1, To: 10}
+	d, _ = newDistribution("exponential", x, 0)
+	for i := 0; i < 100; i++ {
+		if v, _ := strconv.Atoi(d.value()); v < 1 || v > 10 {
+			t.Fatalf("value not clamped: %v", v)
+		}
+	}
+
+	x = &Variable{Seed: 1, S: 2}
+	d, _ = newDistribution("zipf", x, 9)
+	counts := make([]int, 10)
+	for i := 0; i < 10000; i++ {
+		counts[d.index(10)]++
+	}
+	for i := 1; i < 3; i++ {
+		if counts[i] >= counts[i-1] {
+			t.Errorf("expected skewed counts: %v", counts)
+		}
+	}
+
+	if _, err := newDistribution("zipf", &Variable{S: 0.5}, 9); err == nil {
+		t.Error("expected error of invalid s")
+	}
+	if _, err := newDistribution("poisson", &Variable{}, 9); err == nil {
+		t.Error("expected error of unsupported distribution")
+	}
+}
+
+func TestDistributionVariable(t *testing.T) {
+	config := &LoaderConfig{Variable: []Variable{
+		{Name: "term", Type: "list", Data: []string{"a", "b", "c", "d"}, Distribution: "hotspot", HotFraction: 0.25, HotRate: 1},
+		{Name: "id", Type: "zipf", From: 1, To: 100},
+	}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if v := getVariable("term", 0); v != "a" {
+			t.Fatalf("expected the hot term, got: %s", v)
+		}
+		if v, _ := strconv.Atoi(getVariable("id", 0)); v < 1 || v > 100 {
+			t.Fatalf("value out of range: %v", v)
+		}
+	}
+
+	config = &LoaderConfig{Variable: []Variable{{Name: "id", Type: "hotspot", From: 10, To: 10}}}
+	if err := config.Init(); err == nil {
+		t.Error("expected error of invalid range")
+	}
+}
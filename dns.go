@@ -0,0 +1,256 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostResolver resolves hosts of direct TCP connections with overrides, a
+// custom DNS server and a cache.
+type hostResolver struct {
+	// IPs of "host:port" or "host:*"
+	overrides map[string][]string
+	resolver  *net.Resolver
+	ttl       time.Duration
+	// Look up the host for each connection, the first IP is used
+	perConnection bool
+
+	next  uint32
+	lock  sync.RWMutex
+	cache map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ips    []string
+	expiry time.Time
+}
+
+// connResolver is used by dialConn if configured.
+var connResolver *hostResolver
+
+// dnsStats counts the DNS lookups of all connections.
+var dnsStats struct {
+	lookups   int64
+	errors    int64
+	cacheHits int64
+	overrides int64
+	nanos     int64
+	// Connections of each IP, *int64 by IP
+	conns sync.Map
+}
+
+// resetDNSStats clears dnsStats before a run.
+func resetDNSStats() {
+	atomic.StoreInt64(&dnsStats.lookups, 0)
+	atomic.StoreInt64(&dnsStats.errors, 0)
+	atomic.StoreInt64(&dnsStats.cacheHits, 0)
+	atomic.StoreInt64(&dnsStats.overrides, 0)
+	atomic.StoreInt64(&dnsStats.nanos, 0)
+	dnsStats.conns.Range(func(k, v interface{}) bool {
+		dnsStats.conns.Delete(k)
+		return true
+	})
+}
+
+// newHostResolver parses the overrides in the curl `--resolve` format of
+// HOST:PORT:ADDR[,ADDR]..., PORT can be *.
+func newHostResolver(resolve []string, server string, ttl time.Duration, perConnection bool) (*hostResolver, error) {
+	r := &hostResolver{
+		overrides:     map[string][]string{},
+		ttl:           ttl,
+		perConnection: perConnection,
+		cache:         map[string]*dnsEntry{},
+		resolver:      net.DefaultResolver,
+	}
+	for _, item := range resolve {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resolve [%s], expected HOST:PORT:ADDR", item)
+		}
+		var ips []string
+		for _, addr := range strings.Split(parts[2], ",") {
+			addr = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(addr), "["), "]")
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("invalid address [%s] of resolve [%s]", addr, item)
+			}
+			ips = append(ips, addr)
+		}
+		r.overrides[strings.ToLower(parts[0])+":"+parts[1]] = ips
+	}
+
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialer := net.Dialer{}
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r, nil
+}
+
+// resolve returns addr with the host replaced by an IP, IPs are rotated for
+// each connection.
+func (r *hostResolver) resolve(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	host = strings.ToLower(host)
+
+	ips, ok := r.overrides[host+":"+port]
+	if !ok {
+		ips, ok = r.overrides[host+":*"]
+	}
+	if ok {
+		atomic.AddInt64(&dnsStats.overrides, 1)
+		return r.pick(ips, port), nil
+	}
+
+	if r.perConnection {
+		if ips, err = r.lookup(host); err != nil {
+			return "", err
+		}
+		return r.pick(ips[:1], port), nil
+	}
+
+	r.lock.RLock()
+	entry := r.cache[host]
+	r.lock.RUnlock()
+	if entry != nil && time.Now().Before(entry.expiry) {
+		atomic.AddInt64(&dnsStats.cacheHits, 1)
+		return r.pick(entry.ips, port), nil
+	}
+	if ips, err = r.lookup(host); err != nil {
+		return "", err
+	}
+	r.lock.Lock()
+	r.cache[host] = &dnsEntry{ips: ips, expiry: time.Now().Add(r.ttl)}
+	r.lock.Unlock()
+	return r.pick(ips, port), nil
+}
+
+// lookup resolves the IPs of host, IPv6 addresses are only used if there is
+// no IPv4 address.
+func (r *hostResolver) lookup(host string) ([]string, error) {
+	timeout := 5 * time.Second
+	if dialTimeout > 0 {
+		timeout = time.Duration(dialTimeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := r.resolver.LookupIPAddr(ctx, host)
+	atomic.AddInt64(&dnsStats.nanos, int64(time.Since(start)))
+	atomic.AddInt64(&dnsStats.lookups, 1)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no address of host [%s]", host)
+	}
+	if err != nil {
+		atomic.AddInt64(&dnsStats.errors, 1)
+		return nil, err
+	}
+
+	var ipv4, ipv6 []string
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ipv4 = append(ipv4, addr.IP.String())
+		} else {
+			ipv6 = append(ipv6, addr.IP.String())
+		}
+	}
+	if len(ipv4) > 0 {
+		return ipv4, nil
+	}
+	return ipv6, nil
+}
+
+func (r *hostResolver) pick(ips []string, port string) string {
+	ip := ips[0]
+	if len(ips) > 1 {
+		ip = ips[int(atomic.AddUint32(&r.next, 1)-1)%len(ips)]
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// countConn counts the connection of the IP of addr.
+func countConn(addr string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	v, ok := dnsStats.conns.Load(host)
+	if !ok {
+		v, _ = dnsStats.conns.LoadOrStore(host, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+// printDNSStats prints the lookups and the connections of each IP.
+func printDNSStats() {
+	lookups := atomic.LoadInt64(&dnsStats.lookups)
+	overrides := atomic.LoadInt64(&dnsStats.overrides)
+	if lookups == 0 && overrides == 0 {
+		return
+	}
+	fmt.Println("\n[DNS Metrics]")
+	fmt.Printf("DNS Lookups:\t\t%v\n", lookups)
+	if lookups > 0 {
+		fmt.Printf("Avg Lookup Time:\t%v\n", time.Duration(atomic.LoadInt64(&dnsStats.nanos)/lookups))
+	}
+	fmt.Printf("Lookup Errors:\t\t%v\n", atomic.LoadInt64(&dnsStats.errors))
+	fmt.Printf("Cache Hits:\t\t%v\n", atomic.LoadInt64(&dnsStats.cacheHits))
+	fmt.Printf("Host Overrides:\t\t%v\n", overrides)
+
+	var ips []string
+	dnsStats.conns.Range(func(k, v interface{}) bool {
+		ips = append(ips, k.(string))
+		return true
+	})
+	sort.Strings(ips)
+	for _, ip := range ips {
+		v, _ := dnsStats.conns.Load(ip)
+		fmt.Printf("Connections %s:\t%v\n", ip, atomic.LoadInt64(v.(*int64)))
+	}
+}
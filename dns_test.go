@@ -0,0 +1,128 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolveOverrides(t *testing.T) {
+	r, err := newHostResolver([]string{"es.example.com:9200:10.0.0.1,10.0.0.2", "ES.example.com:*:10.0.0.3", "v6.example.com:443:[::1]"}, "", time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr     string
+		expected []string
+	}{
+		{"es.example.com:9200", []string{"10.0.0.1:9200", "10.0.0.2:9200", "10.0.0.1:9200"}},
+		{"es.example.com:9300", []string{"10.0.0.3:9300"}},
+		{"v6.example.com:443", []string{"[::1]:443"}},
+		{"10.0.0.9:9200", []string{"10.0.0.9:9200"}},
+	}
+	for _, test := range tests {
+		for _, expected := range test.expected {
+			if addr, err := r.resolve(test.addr); err != nil || addr != expected {
+				t.Errorf("expected %s of %s, got %s, %v", expected, test.addr, addr, err)
+			}
+		}
+	}
+
+	for _, resolve := range []string{"es.example.com:9200", "es.example.com:9200:es", ":9200:10.0.0.1"} {
+		if _, err := newHostResolver([]string{resolve}, "", time.Minute, false); err == nil {
+			t.Errorf("expected error of %s", resolve)
+		}
+	}
+}
+
+// serveDNS answers A queries with ip.
+func serveDNS(t *testing.T, ip [4]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err = msg.Unpack(buf[:n]); err != nil || len(msg.Questions) == 0 {
+				continue
+			}
+			msg.Header.Response = true
+			question := msg.Questions[0]
+			if question.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: ip},
+				}}
+			}
+			packed, _ := msg.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestResolveDNSServer(t *testing.T) {
+	server := serveDNS(t, [4]byte{10, 1, 2, 3})
+
+	r, err := newHostResolver(nil, server, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := atomic.LoadInt64(&dnsStats.lookups)
+	hits := atomic.LoadInt64(&dnsStats.cacheHits)
+	for i := 0; i < 3; i++ {
+		if addr, err := r.resolve("node.cluster.test:9200"); err != nil || addr != "10.1.2.3:9200" {
+			t.Fatalf("unexpected address: %s, %v", addr, err)
+		}
+	}
+	if atomic.LoadInt64(&dnsStats.lookups) != lookups+1 || atomic.LoadInt64(&dnsStats.cacheHits) != hits+2 {
+		t.Errorf("expected 1 lookup and 2 cache hits")
+	}
+
+	r, err = newHostResolver(nil, server, time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups = atomic.LoadInt64(&dnsStats.lookups)
+	for i := 0; i < 3; i++ {
+		if _, err := r.resolve("node.cluster.test:9200"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt64(&dnsStats.lookups) != lookups+3 {
+		t.Errorf("expected a lookup for each connection")
+	}
+}
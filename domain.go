@@ -29,10 +29,13 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"infini.sh/framework/core/model"
-	"math/rand"
+	"io"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -62,9 +65,26 @@ type Request struct {
 	Body       string `config:"body"`
 	SimpleMode bool   `config:"simple_mode"`
 
+	// Send a multipart/form-data body instead of body
+	Multipart []MultipartPart `config:"multipart"`
+
+	// Read the body from the file instead of body, binary files are sent as is
+	BodyFile string `config:"body_file"`
+	// whole (default), sequential_chunk or random_chunk
+	BodyFileMode string `config:"body_file_mode"`
+	// Number of lines of each chunk, default: 1000
+	BodyFileChunkLines int `config:"body_file_chunk_lines"`
+	// Render the variables of each line of the body file
+	BodyFileTemplate bool `config:"body_file_template"`
+
 	RepeatBodyNTimes int                 `config:"body_repeat_times"`
 	Headers          []map[string]string `config:"headers"`
 	BasicAuth        *model.BasicAuth    `config:"basic_auth"`
+	// Token, API key, AWS SigV4 or OAuth2 auth, overrides basic_auth
+	Auth *AuthConfig `config:"auth"`
+	// Escape values rendered into the body, url and headers, default:
+	// runner.default_escape
+	Escape *EscapeConfig `config:"escape"`
 
 	// Disable fasthttp client's header names normalizing, preserve original header key, for requests
 	DisableHeaderNamesNormalizing bool `config:"disable_header_names_normalizing"`
@@ -74,6 +94,22 @@ type Request struct {
 
 	ExecuteRepeatTimes int `config:"execute_repeat_times"`
 
+	// Compress the body, default: runner.compression
+	Compression *CompressionConfig `config:"compression"`
+
+	// Read the response as a stream of events
+	Stream       *StreamConfig `config:"stream"`
+	streamClient *http.Client
+
+	// Follow 3xx responses, default: runner.follow_redirects
+	FollowRedirects *RedirectConfig `config:"follow_redirects"`
+
+	// TLS settings of this request, default: runner.tls
+	TLS       *TLSConfig `config:"tls"`
+	tlsConfig *tls.Config
+	client    requestDoer
+	bodyFile  *bodyFile
+
 	urlHasTemplate    bool
 	headerHasTemplate bool
 	bodyHasTemplate   bool
@@ -81,10 +117,18 @@ type Request struct {
 	headerTemplates map[string]*fasttemplate.Template
 	urlTemplate     *fasttemplate.Template
 	bodyTemplate    *fasttemplate.Template
+
+	bodyEscape    string
+	bodyEscapes   placeholderEscapes
+	urlEscapes    placeholderEscapes
+	headerEscapes map[string]placeholderEscapes
+
+	runtimeVariables  *orderedVariables
+	bodyLineVariables *orderedVariables
 }
 
 func (req *Request) HasVariable() bool {
-	return req.urlHasTemplate || req.bodyHasTemplate || len(req.headerTemplates) > 0
+	return req.urlHasTemplate || req.bodyHasTemplate || len(req.headerTemplates) > 0 || len(req.Multipart) > 0 || req.BodyFileTemplate
 }
 
 type Variable struct {
@@ -108,7 +152,64 @@ type Variable struct {
 	RandomSquareBracketChar bool   `config:"square_bracket"`
 	RandomStringBracketChar string `config:"string_bracket"`
 
-	replacer *strings.Replacer
+	//type: person_name, email, lorem_words, etc., `size` is the number of
+	//words or sentences of lorem types
+	// Locale of the values, en (default) or zh
+	Locale string `config:"locale"`
+
+	//type: zipf, normal, log_normal, exponential, pareto and hotspot, values
+	//are clamped to `from` and `to` if `to` is set
+	// Distribution of the values of file and list, default: uniform
+	Distribution string `config:"distribution"`
+	// zipf: P(k) is proportional to (v + k) ** (-s), default: s=1.1, v=1
+	S float64 `config:"s"`
+	V float64 `config:"v"`
+	// normal, default: mean=0, stddev=1
+	Mean   float64 `config:"mean"`
+	Stddev float64 `config:"stddev"`
+	// log_normal, default: mu=0, sigma=1
+	Mu    float64 `config:"mu"`
+	Sigma float64 `config:"sigma"`
+	// exponential, default: rate=1
+	Rate float64 `config:"rate"`
+	// pareto, default: alpha=1.16 (80/20 rule), xm=1
+	Alpha float64 `config:"alpha"`
+	Xm    float64 `config:"xm"`
+	// hotspot: hot_rate of hits go to hot_fraction of keys, default: 0.8 and 0.2
+	HotRate     float64 `config:"hot_rate"`
+	HotFraction float64 `config:"hot_fraction"`
+	// Decimal places of float values, default: 0 (integers)
+	Precision int `config:"precision"`
+
+	//type: csv, the first row of `path` is the header
+	// Delimiter of columns, default: `,` or tab if the file ends with .tsv
+	Delimiter string `config:"delimiter"`
+	// rfc4180 (default), lazy (allow quotes in unquoted fields) or none
+	Quoting string `config:"quoting"`
+	// Order of csv rows: sequential (default, rows are shared by all VUs),
+	// random or shuffle; order of file and list values: random (default),
+	// sequential or shuffle_once
+	Order string `config:"order"`
+	// Select a new row each iteration (default) or once per VU
+	Bind string `config:"bind"`
+
+	//type: file and list, with order sequential or shuffle_once
+	// Values are consumed once by all VUs, default: false (iterated by each VU)
+	UniqueAcrossGoroutines bool `config:"unique_across_goroutines"`
+	// When csv rows or file and list values are exhausted: recycle (default)
+	// or stop; stop ends the run for csv rows and the VU for values, csv rows
+	// also support stop_vu
+	OnExhaust string `config:"on_exhaust"`
+
+	// Generate the same sequence of values of faker, distribution types and
+	// shuffle_once, default: 0 (random)
+	Seed int64 `config:"seed"`
+
+	replacer     *strings.Replacer
+	faker        *faker
+	distribution *distribution
+	csv          *csvSource
+	cursor       *listCursor
 }
 
 type AppConfig struct {
@@ -154,14 +255,96 @@ type RunnerConfig struct {
 	// Disable fasthttp client's header names normalizing, preserve original header key, for responses
 	DisableHeaderNamesNormalizing bool `config:"disable_header_names_normalizing"`
 
+	// Seed of random values, a run can be reproduced with the same seed,
+	// overridden by `-seed`. Default: 0 (random seed)
+	Seed int64 `config:"seed"`
+
 	// Whether to reset the context, including variables, runtime KV pairs, etc.,
 	// before this test run.
 	ResetContext bool `config:"reset_context"`
 
+	// Compress the body of all requests, overrides `-compress`
+	Compression *CompressionConfig `config:"compression"`
+
+	// Skip decoding compressed responses by Content-Encoding before assertions
+	// and registration
+	DisableResponseDecompression bool `config:"disable_response_decompression"`
+
+	// Follow 3xx responses of all requests
+	FollowRedirects *RedirectConfig `config:"follow_redirects"`
+
+	// Keep cookies for each thread, cookies are accessed by `$[[cookie.NAME]]`
+	CookieJar bool `config:"cookie_jar"`
+	// Clear the cookies at the start of each round
+	ResetCookiesEachRound bool `config:"reset_cookies_each_round"`
+
+	// HTTP protocol to send requests: http1 (default), h2 (HTTP/2 over TLS) or
+	// h2c (HTTP/2 over cleartext TCP)
+	Protocol string `config:"protocol"`
+	// Number of HTTP/2 connections per host, default: 1
+	H2Connections int `config:"h2_connections"`
+	// Max concurrent streams per HTTP/2 connection, default: 0 (limited by the server)
+	H2MaxConcurrentStreams int `config:"h2_max_concurrent_streams"`
+
+	// Max connections per host of HTTP/1.1, default: number of concurrent threads
+	MaxConnsPerHost int `config:"max_conns_per_host"`
+	// Close idle HTTP/1.1 connections after the duration, default: 10000
+	MaxConnIdleInMilliSeconds int64 `config:"max_conn_idle_in_milli_seconds"`
+	// Close HTTP/1.1 connections after the duration, default: 0 (unlimited)
+	MaxConnLifetimeInMilliSeconds int64 `config:"max_conn_lifetime_in_milli_seconds"`
+	// Open a new HTTP/1.1 connection for every request
+	DisableKeepAlive bool `config:"disable_keep_alive"`
+	// Open a new HTTP/1.1 connection every N requests of a thread, default: 0 (never)
+	MaxConnRequests int `config:"max_conn_requests"`
+
+	// Source IPs or CIDRs of outgoing TCP connections and UDP sockets, used in
+	// turn by each new connection
+	LocalAddresses []string `config:"local_addresses"`
+	// How local addresses are chosen, round_robin (default) rotates per
+	// connection, per_vu pins one to the HTTP requests of each VU
+	LocalAddressStrategy string `config:"local_address_strategy"`
+	localAddrs           *localAddrPool
+
+	// Resolve hosts to the addresses instead of DNS, in the curl `--resolve`
+	// format of HOST:PORT:ADDR[,ADDR]..., PORT can be *
+	Resolve []string `config:"resolve"`
+	// DNS server to resolve hosts, e.g.: 8.8.8.8:53, default: system resolver
+	DNSServer string `config:"dns_server"`
+	// Cache the resolved IPs for the duration, default: 60000
+	DNSCacheTTLInMilliSeconds int64 `config:"dns_cache_ttl_in_milli_seconds"`
+	// Resolve the host for each new connection instead of caching
+	DNSResolvePerConnection bool `config:"dns_resolve_per_connection"`
+	resolver                *hostResolver
+
+	// TLS settings of all connections
+	TLS       *TLSConfig `config:"tls"`
+	tlsConfig *tls.Config
+
+	// Send TCP connections through a HTTP CONNECT or SOCKS5 proxy
+	Proxy *ProxyConfig `config:"proxy"`
+	proxy *proxyDialer
+
 	// Default endpoint if not specified in a request
 	DefaultEndpoint  string           `config:"default_endpoint"`
 	DefaultBasicAuth *model.BasicAuth `config:"default_basic_auth"`
 	defaultEndpoint  *fasthttp.URI
+
+	// Default auth of requests without auth or basic_auth
+	DefaultAuth *AuthConfig `config:"default_auth"`
+	// Default escaping of values rendered into requests, default: none
+	DefaultEscape *EscapeConfig `config:"default_escape"`
+
+	// Balance requests without host across multiple endpoints, overrides
+	// `default_endpoint` for HTTP requests
+	DefaultEndpoints []string `config:"default_endpoints"`
+	// How to pick the endpoint: round_robin (default), random, least_inflight
+	// or sticky (per virtual user)
+	EndpointStrategy string `config:"endpoint_strategy"`
+	// Eject an endpoint after N consecutive failures, default: 0 (never)
+	EndpointMaxFailures int `config:"endpoint_max_failures"`
+	// How long an endpoint is ejected, default: 30000
+	EndpointEjectInMilliSeconds int64 `config:"endpoint_eject_in_milli_seconds"`
+	endpoints                   *endpointPool
 }
 
 func (config *RunnerConfig) parseDefaultEndpoint() (*fasthttp.URI, error) {
@@ -179,6 +362,16 @@ func (config *RunnerConfig) parseDefaultEndpoint() (*fasthttp.URI, error) {
 		return config.defaultEndpoint, err
 	}
 
+	if len(config.DefaultEndpoints) > 0 {
+		uri := &fasthttp.URI{}
+		err := uri.Parse(nil, []byte(config.DefaultEndpoints[0]))
+		if err != nil {
+			return nil, err
+		}
+		config.defaultEndpoint = uri
+		return config.defaultEndpoint, err
+	}
+
 	return config.defaultEndpoint, errors.New("no valid default endpoint")
 }
 
@@ -204,6 +397,8 @@ const (
 var (
 	dict      = map[string][]string{}
 	variables map[string]Variable
+	// Sorted names of variables, sources are iterated in this order
+	variableNames []string
 )
 
 func (config *AppConfig) Init() {
@@ -219,10 +414,87 @@ func (config *AppConfig) testEnv(envVars ...string) bool {
 	return true
 }
 
+// close releases the files opened by Init.
+func (config *LoaderConfig) close() {
+	for _, v := range config.Requests {
+		if v.Request != nil && v.Request.bodyFile != nil {
+			v.Request.bodyFile.close()
+			v.Request.bodyFile = nil
+		}
+	}
+}
+
 func (config *LoaderConfig) Init() error {
+	switch config.RunnerConfig.Protocol {
+	case "", protocolHTTP1, protocolH2, protocolH2C:
+	default:
+		return fmt.Errorf("unsupported protocol [%s]", config.RunnerConfig.Protocol)
+	}
+
+	tlsConfig, err := newTLSConfig(config.RunnerConfig.TLS)
+	if err != nil {
+		return err
+	}
+	config.RunnerConfig.tlsConfig = tlsConfig
+
+	if config.RunnerConfig.Compression != nil {
+		if err = config.RunnerConfig.Compression.init(); err != nil {
+			return err
+		}
+	}
+
+	if config.RunnerConfig.DefaultAuth != nil {
+		if err = config.RunnerConfig.DefaultAuth.init(); err != nil {
+			return err
+		}
+	}
+	if config.RunnerConfig.DefaultEscape != nil {
+		if err = config.RunnerConfig.DefaultEscape.init(); err != nil {
+			return err
+		}
+	}
+
+	config.RunnerConfig.localAddrs = nil
+	if len(config.RunnerConfig.LocalAddresses) > 0 {
+		if config.RunnerConfig.localAddrs, err = newLocalAddrPool(config.RunnerConfig.LocalAddresses, config.RunnerConfig.LocalAddressStrategy); err != nil {
+			return err
+		}
+	}
+
+	config.RunnerConfig.resolver = nil
+	if len(config.RunnerConfig.Resolve) > 0 || config.RunnerConfig.DNSServer != "" ||
+		config.RunnerConfig.DNSCacheTTLInMilliSeconds > 0 || config.RunnerConfig.DNSResolvePerConnection {
+		if config.RunnerConfig.DNSCacheTTLInMilliSeconds <= 0 {
+			config.RunnerConfig.DNSCacheTTLInMilliSeconds = 60000
+		}
+		if config.RunnerConfig.resolver, err = newHostResolver(config.RunnerConfig.Resolve, config.RunnerConfig.DNSServer,
+			time.Duration(config.RunnerConfig.DNSCacheTTLInMilliSeconds)*time.Millisecond, config.RunnerConfig.DNSResolvePerConnection); err != nil {
+			return err
+		}
+	}
+
+	config.RunnerConfig.proxy = nil
+	if config.RunnerConfig.Proxy != nil && config.RunnerConfig.Proxy.Url != "" {
+		if config.RunnerConfig.proxy, err = newProxyDialer(config.RunnerConfig.Proxy); err != nil {
+			return err
+		}
+	}
+
+	config.RunnerConfig.endpoints = nil
+	if len(config.RunnerConfig.DefaultEndpoints) > 0 {
+		endpoints, err := newEndpointPool(&config.RunnerConfig)
+		if err != nil {
+			return err
+		}
+		config.RunnerConfig.endpoints = endpoints
+	}
+
+	config.RunnerConfig.Seed = initSeed(config.RunnerConfig.Seed)
+
 	// As we do not allow duplicate variable definitions, it is necessary to clear
 	// any previously defined variables.
 	variables = map[string]Variable{}
+	variableNames = nil
 	if config.RunnerConfig.ResetContext {
 		dict = map[string][]string{}
 		util.ClearAllID()
@@ -234,6 +506,14 @@ func (config *LoaderConfig) Init() error {
 		if ok {
 			return fmt.Errorf("variable [%s] defined twice", i.Name)
 		}
+		if i.Type == "csv" {
+			if i.csv, err = newCSVSource(&i); err != nil {
+				return fmt.Errorf("invalid variable [%s]: %v", i.Name, err)
+			}
+			variables[i.Name] = i
+			continue
+		}
+
 		var lines []string
 		if len(i.Path) > 0 {
 			lines = util.FileGetLines(i.Path)
@@ -258,16 +538,100 @@ func (config *LoaderConfig) Init() error {
 			i.replacer = strings.NewReplacer(replaces...)
 		}
 
+		if fakerTypes[i.Type] {
+			if i.faker, err = newFaker(i.Locale, variableSeed(&i)); err != nil {
+				return fmt.Errorf("invalid variable [%s]: %v", i.Name, err)
+			}
+		}
+		if distributionTypes[i.Type] {
+			if (i.Type == "zipf" || i.Type == "hotspot") && i.To <= i.From {
+				return fmt.Errorf("invalid variable [%s]: `to` must be greater than `from`", i.Name)
+			}
+			if i.distribution, err = newDistribution(i.Type, &i, i.To-i.From); err != nil {
+				return fmt.Errorf("invalid variable [%s]: %v", i.Name, err)
+			}
+		} else if (i.Type == "file" || i.Type == "list") && i.Distribution != "" && i.Distribution != "uniform" {
+			var imax uint64
+			if len(lines) > 1 {
+				imax = uint64(len(lines) - 1)
+			}
+			if i.distribution, err = newDistribution(i.Distribution, &i, imax); err != nil {
+				return fmt.Errorf("invalid variable [%s]: %v", i.Name, err)
+			}
+		}
+		if (i.Type == "file" || i.Type == "list") && i.Order != "" && i.Order != orderRandom {
+			if i.cursor, err = newListCursor(&i, lines); err != nil {
+				return fmt.Errorf("invalid variable [%s]: %v", i.Name, err)
+			}
+		}
+
 		dict[i.Name] = lines
 
 		variables[i.Name] = i
 	}
+	for name := range variables {
+		variableNames = append(variableNames, name)
+	}
+	sort.Strings(variableNames)
 
-	var err error
 	for _, v := range config.Requests {
+		if v.WebSocket != nil {
+			if err = v.WebSocket.init(); err != nil {
+				return err
+			}
+		}
+		if v.GRPC != nil {
+			if err = v.GRPC.init(); err != nil {
+				return err
+			}
+		}
+		if v.TCP != nil {
+			if err = v.TCP.init(networkTCP); err != nil {
+				return err
+			}
+		}
+		if v.UDP != nil {
+			if err = v.UDP.init(networkUDP); err != nil {
+				return err
+			}
+		}
 		if v.Request == nil {
 			continue
 		}
+		if v.Request.Compression != nil {
+			if err = v.Request.Compression.init(); err != nil {
+				return err
+			}
+		}
+		if v.Request.Auth != nil {
+			if err = v.Request.Auth.init(); err != nil {
+				return err
+			}
+		}
+		if v.Request.Stream != nil {
+			if err = v.Request.Stream.init(v.Request.Url); err != nil {
+				return err
+			}
+		}
+
+		if v.Request.TLS != nil {
+			if v.Request.tlsConfig, err = newTLSConfig(v.Request.TLS); err != nil {
+				return err
+			}
+		}
+
+		v.Request.runtimeVariables = newOrderedVariables(v.Request.RuntimeVariables)
+		v.Request.bodyLineVariables = newOrderedVariables(v.Request.RuntimeBodyLineVariables)
+		for _, runtimeVariables := range []map[string]string{v.Request.RuntimeVariables, v.Request.RuntimeBodyLineVariables} {
+			for _, tag := range runtimeVariables {
+				if !isPlainTag(tag) {
+					if e := compileExpression(tag); e.err != nil {
+						return e.err
+					}
+				}
+			}
+		}
+
 		v.Request.headerTemplates = map[string]*fasttemplate.Template{}
 		if util.ContainStr(v.Request.Url, "$[[") {
 			v.Request.urlHasTemplate = true
@@ -275,6 +639,9 @@ func (config *LoaderConfig) Init() error {
 			if err != nil {
 				return err
 			}
+			if err = compileExpressions(v.Request.Url); err != nil {
+				return err
+			}
 		}
 
 		if v.Request.RepeatBodyNTimes <= 0 && len(v.Request.Body) > 0 {
@@ -287,6 +654,32 @@ func (config *LoaderConfig) Init() error {
 			if err != nil {
 				return err
 			}
+			if err = compileExpressions(v.Request.Body); err != nil {
+				return err
+			}
+		}
+
+		if len(v.Request.Multipart) > 0 && len(v.Request.Body) > 0 {
+			return fmt.Errorf("request [%s] can't have both body and multipart", v.Request.Url)
+		}
+		for i := range v.Request.Multipart {
+			if err = v.Request.Multipart[i].init(); err != nil {
+				return err
+			}
+		}
+		if c := v.Request.compression(config); len(v.Request.Multipart) > 0 && c != nil && c.Codec != codecNone {
+			if v.Request.Compression != nil {
+				return fmt.Errorf("request [%s] can't have both multipart and compression", v.Request.Url)
+			}
+			log.Warnf("multipart body of request [%s] is not compressed", v.Request.Url)
+		}
+		if v.Request.BodyFile != "" {
+			if len(v.Request.Body) > 0 || len(v.Request.Multipart) > 0 {
+				return fmt.Errorf("request [%s] can't have both body_file and body or multipart", v.Request.Url)
+			}
+			if v.Request.bodyFile, err = newBodyFile(v.Request.BodyFile, v.Request.BodyFileMode, v.Request.BodyFileChunkLines); err != nil {
+				return err
+			}
 		}
 
 		for _, headers := range v.Request.Headers {
@@ -297,9 +690,15 @@ func (config *LoaderConfig) Init() error {
 					if err != nil {
 						return err
 					}
+					if err = compileExpressions(headerV); err != nil {
+						return err
+					}
 				}
 			}
 		}
+		if err = v.Request.initEscapes(config.RunnerConfig.DefaultEscape); err != nil {
+			return fmt.Errorf("request [%s]: %v", v.Request.Url, err)
+		}
 
 		////if there is no $[[ in the request, then we can assume that the request is in simple mode
 		//if !v.Request.urlHasTemplate && !v.Request.bodyHasTemplate&& !v.Request.headerHasTemplate {
@@ -314,6 +713,9 @@ func (config *LoaderConfig) Init() error {
 const TsLayout = "2006-01-02T15:04:05.000"
 
 func GetVariable(runtimeKV util.MapStr, key string) string {
+	if !isPlainTag(key) {
+		return evalExpression(runtimeKV, key)
+	}
 
 	if runtimeKV != nil {
 		x, err := runtimeKV.GetValue(key)
@@ -322,23 +724,76 @@ func GetVariable(runtimeKV util.MapStr, key string) string {
 		}
 	}
 
-	return getVariable(key)
+	return getVariable(key, vuOf(runtimeKV))
+}
+
+// newTemplate compiles the string if it contains any variable, otherwise it
+// returns nil.
+func newTemplate(s string) (*fasttemplate.Template, error) {
+	if !util.ContainStr(s, "$[[") {
+		return nil, nil
+	}
+	if err := compileExpressions(s); err != nil {
+		return nil, err
+	}
+	return fasttemplate.NewTemplate(s, "$[[", "]]")
+}
+
+// renderTemplate executes the template with runtime variables, or returns the
+// raw string if there is no template.
+func renderTemplate(tmpl *fasttemplate.Template, raw string, runtimeKV util.MapStr) string {
+	if tmpl == nil {
+		return raw
+	}
+	return tmpl.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		variable := GetVariable(runtimeKV, tag)
+		return w.Write(util.UnsafeStringToBytes(variable))
+	})
+}
+
+// orderedVariables are runtime variables evaluated in the order of names, so
+// that random values are the same between runs of a seed.
+type orderedVariables struct {
+	names []string
+	tags  map[string]string
+}
+
+func newOrderedVariables(tags map[string]string) *orderedVariables {
+	if len(tags) == 0 {
+		return nil
+	}
+	v := &orderedVariables{tags: tags}
+	for name := range tags {
+		v.names = append(v.names, name)
+	}
+	sort.Strings(v.names)
+	return v
+}
+
+// put evaluates the variables into runtimeKV.
+func (v *orderedVariables) put(runtimeKV util.MapStr) {
+	if v == nil {
+		return
+	}
+	for _, name := range v.names {
+		runtimeKV.Put(name, GetVariable(runtimeKV, v.tags[name]))
+	}
 }
 
-func getVariable(key string) string {
+func getVariable(key string, vu int) string {
 	x, ok := variables[key]
 	if !ok {
 		return "not_found"
 	}
 
-	rawValue := buildVariableValue(x)
+	rawValue := buildVariableValue(x, vu)
 	if x.replacer == nil {
 		return rawValue
 	}
 	return x.replacer.Replace(rawValue)
 }
 
-func buildVariableValue(x Variable) string {
+func buildVariableValue(x Variable, vu int) string {
 	switch x.Type {
 	case "sequence":
 		return util.ToString(util.GetAutoIncrement32ID(x.Name, uint32(x.From), uint32(x.To)).Increment())
@@ -369,7 +824,7 @@ func buildVariableValue(x Variable) string {
 		rb3 := roaring.New()
 		if x.Size > 0 {
 			for y := 0; y < x.Size; y++ {
-				v := rand.Intn(int(x.To-x.From+1)) + int(x.From)
+				v := vuRand(vu).Intn(int(x.To-x.From+1)) + int(x.From)
 				rb3.Add(uint32(v))
 			}
 		}
@@ -377,7 +832,7 @@ func buildVariableValue(x Variable) string {
 		rb3.WriteTo(buf)
 		return base64.StdEncoding.EncodeToString(buf.Bytes())
 	case "range":
-		return util.IntToString(rand.Intn(int(x.To-x.From+1)) + int(x.From))
+		return util.IntToString(vuRand(vu).Intn(int(x.To-x.From+1)) + int(x.From))
 	case "random_array":
 		str := bytes.Buffer{}
 
@@ -392,7 +847,7 @@ func buildVariableValue(x Variable) string {
 						str.WriteString(",")
 					}
 
-					v := getVariable(x.RandomArrayKey)
+					v := getVariable(x.RandomArrayKey, vu)
 
 					//left "
 					if x.RandomArrayType == "string" {
@@ -424,19 +879,39 @@ func buildVariableValue(x Variable) string {
 	case "file", "list":
 		d, ok := dict[x.Name]
 		if ok {
+			if x.cursor != nil {
+				return x.cursor.value(vu)
+			}
 
 			if len(d) == 1 {
 				return d[0]
 			}
-			offset := rand.Intn(len(d))
+			if x.distribution != nil {
+				return d[x.distribution.forVU(vu).index(len(d))]
+			}
+			offset := vuRand(vu).Intn(len(d))
 			return d[offset]
 		}
+	default:
+		if x.faker != nil {
+			return x.faker.forVU(vu).value(x.Type, x.Size)
+		}
+		if x.distribution != nil {
+			return x.distribution.forVU(vu).value()
+		}
 	}
 	return "invalid_variable_type"
 }
 
 type RequestItem struct {
 	Request *Request `config:"request"`
+	// WebSocket conversation to run instead of a HTTP request
+	WebSocket *WebSocketRequest `config:"websocket"`
+	// gRPC call to invoke instead of a HTTP request
+	GRPC *GRPCRequest `config:"grpc"`
+	// Raw payloads to send over TCP or UDP instead of a HTTP request
+	TCP *SocketRequest `config:"tcp"`
+	UDP *SocketRequest `config:"udp"`
 	// TODO: mask invalid gateway fields
 	Assert    *conditions.Config `config:"assert"`
 	AssertDsl string             `config:"assert_dsl"`
@@ -445,6 +920,25 @@ type RequestItem struct {
 	Register []map[string]string `config:"register"`
 }
 
+func (item *RequestItem) String() string {
+	if item.Request != nil {
+		return item.Request.Method + " " + item.Request.Url
+	}
+	if item.WebSocket != nil {
+		return "WEBSOCKET " + item.WebSocket.Url
+	}
+	if item.GRPC != nil {
+		return "GRPC " + item.GRPC.Method
+	}
+	if item.TCP != nil {
+		return "TCP " + item.TCP.Address
+	}
+	if item.UDP != nil {
+		return "UDP " + item.UDP.Address
+	}
+	return "request"
+}
+
 type SleepAction struct {
 	SleepInMilliSeconds int64 `config:"sleep_in_milli_seconds"`
 }
@@ -0,0 +1,255 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	endpointRoundRobin    = "round_robin"
+	endpointRandom        = "random"
+	endpointLeastInflight = "least_inflight"
+	endpointSticky        = "sticky"
+)
+
+// endpoint is one of the default endpoints, requests sent to its host are
+// counted by balancedClient.
+type endpoint struct {
+	url string
+	uri *fasthttp.URI
+
+	inflight int64
+	// Consecutive failures since the last success
+	failures int64
+	// Unix nano until which the endpoint is ejected
+	ejectedUntil int64
+
+	lock           sync.Mutex
+	requests       int
+	errors         int
+	ejections      int
+	totDuration    time.Duration
+	minRequestTime time.Duration
+	maxRequestTime time.Duration
+	statusCode     map[int]int
+}
+
+func (e *endpoint) ejected(now int64) bool {
+	return atomic.LoadInt64(&e.ejectedUntil) > now
+}
+
+// endpointPool picks an endpoint for each request by the strategy, and ejects
+// endpoints after too many consecutive failures.
+type endpointPool struct {
+	strategy    string
+	maxFailures int64
+	ejectTime   time.Duration
+
+	next      uint32
+	endpoints []*endpoint
+	hosts     map[string]*endpoint
+}
+
+func newEndpointPool(config *RunnerConfig) (*endpointPool, error) {
+	pool := &endpointPool{
+		strategy:    config.EndpointStrategy,
+		maxFailures: int64(config.EndpointMaxFailures),
+		ejectTime:   time.Duration(config.EndpointEjectInMilliSeconds) * time.Millisecond,
+		hosts:       map[string]*endpoint{},
+	}
+	switch pool.strategy {
+	case "":
+		pool.strategy = endpointRoundRobin
+	case endpointRoundRobin, endpointRandom, endpointLeastInflight, endpointSticky:
+	default:
+		return nil, fmt.Errorf("unsupported endpoint strategy [%s]", pool.strategy)
+	}
+	if pool.ejectTime <= 0 {
+		pool.ejectTime = 30 * time.Second
+	}
+
+	for _, url := range config.DefaultEndpoints {
+		uri := &fasthttp.URI{}
+		if err := uri.Parse(nil, []byte(url)); err != nil {
+			return nil, err
+		}
+		host := string(uri.Host())
+		if _, ok := pool.hosts[host]; ok {
+			return nil, fmt.Errorf("endpoint [%s] defined twice", url)
+		}
+		e := &endpoint{url: url, uri: uri, statusCode: map[int]int{}}
+		pool.endpoints = append(pool.endpoints, e)
+		pool.hosts[host] = e
+	}
+	return pool, nil
+}
+
+// pick returns the endpoint for the next request of the virtual user,
+// ejected endpoints are skipped unless all of them are ejected.
+func (p *endpointPool) pick(vu int) *endpoint {
+	now := time.Now().UnixNano()
+	healthy := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if !e.ejected(now) {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = p.endpoints
+	}
+
+	switch p.strategy {
+	case endpointRandom:
+		return healthy[vuRand(vu).Intn(len(healthy))]
+	case endpointSticky:
+		// The warm-up (-1) sticks to the endpoint of the first VU
+		i := vu
+		if i < 0 {
+			i = 0
+		}
+		e := p.endpoints[i%len(p.endpoints)]
+		if !e.ejected(now) || len(healthy) == len(p.endpoints) {
+			return e
+		}
+	case endpointLeastInflight:
+		// Start from the next endpoint so that ties are spread
+		offset := int(atomic.AddUint32(&p.next, 1))
+		var picked *endpoint
+		for i := range healthy {
+			e := healthy[(offset+i)%len(healthy)]
+			if picked == nil || atomic.LoadInt64(&e.inflight) < atomic.LoadInt64(&picked.inflight) {
+				picked = e
+			}
+		}
+		return picked
+	}
+	return healthy[int(atomic.AddUint32(&p.next, 1)-1)%len(healthy)]
+}
+
+// done records the result of a request, the endpoint is ejected if it failed
+// maxFailures times in a row.
+func (p *endpointPool) done(e *endpoint, statusCode int, duration time.Duration, err error) {
+	e.lock.Lock()
+	if e.requests == 0 || duration < e.minRequestTime {
+		e.minRequestTime = duration
+	}
+	e.maxRequestTime = util.MaxDuration(duration, e.maxRequestTime)
+	e.totDuration += duration
+	e.requests++
+	e.statusCode[statusCode] += 1
+	if err != nil {
+		e.errors++
+	}
+	e.lock.Unlock()
+
+	if err == nil {
+		atomic.StoreInt64(&e.failures, 0)
+		return
+	}
+	if p.maxFailures <= 0 || atomic.AddInt64(&e.failures, 1) < p.maxFailures {
+		return
+	}
+	atomic.StoreInt64(&e.failures, 0)
+	atomic.StoreInt64(&e.ejectedUntil, time.Now().Add(p.ejectTime).UnixNano())
+	e.lock.Lock()
+	e.ejections++
+	e.lock.Unlock()
+	log.Warnf("endpoint [%s] ejected for %v after %v failures, last error: %v", e.url, p.ejectTime, p.maxFailures, err)
+}
+
+// pickEndpoint returns the endpoint for requests without host, picked from
+// default endpoints if configured.
+func (config *RunnerConfig) pickEndpoint(vu int) (*fasthttp.URI, error) {
+	if config.endpoints == nil {
+		return config.parseDefaultEndpoint()
+	}
+	return config.endpoints.pick(vu).uri, nil
+}
+
+// balancedClient tracks in-flight requests, failures and stats of requests
+// sent to the default endpoints.
+type balancedClient struct {
+	requestDoer
+	pool *endpointPool
+}
+
+func (c *balancedClient) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	return c.DoTimeout(req, resp, 0)
+}
+
+func (c *balancedClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	e, ok := c.pool.hosts[string(req.URI().Host())]
+	if !ok {
+		if timeout > 0 {
+			return c.requestDoer.DoTimeout(req, resp, timeout)
+		}
+		return c.requestDoer.Do(req, resp)
+	}
+
+	atomic.AddInt64(&e.inflight, 1)
+	start := time.Now()
+	var err error
+	if timeout > 0 {
+		err = c.requestDoer.DoTimeout(req, resp, timeout)
+	} else {
+		err = c.requestDoer.Do(req, resp)
+	}
+	atomic.AddInt64(&e.inflight, -1)
+	c.pool.done(e, resp.StatusCode(), time.Since(start), err)
+	return err
+}
+
+// printStats prints the summary of each endpoint, so that the imbalance of
+// nodes can be compared.
+func (p *endpointPool) printStats(duration time.Duration) {
+	for _, e := range p.endpoints {
+		e.lock.Lock()
+		fmt.Printf("\n[Endpoint Metrics: %s]\n", e.url)
+		fmt.Printf("Requests:\t\t%v\n", e.requests)
+		fmt.Printf("Requests/sec:\t\t%.2f\n", float64(e.requests)/duration.Seconds())
+		if e.requests > 0 {
+			fmt.Printf("Avg Req Time:\t\t%v\n", e.totDuration/time.Duration(e.requests))
+			fmt.Printf("Fastest Request:\t%v\n", e.minRequestTime)
+			fmt.Printf("Slowest Request:\t%v\n", e.maxRequestTime)
+		}
+		fmt.Printf("Number of Errors:\t%v\n", e.errors)
+		fmt.Printf("Ejections:\t\t%v\n", e.ejections)
+		for k, v := range e.statusCode {
+			fmt.Printf("Status %v:\t\t%v\n", k, v)
+		}
+		e.lock.Unlock()
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestEndpointStrategies(t *testing.T) {
+	config := &RunnerConfig{DefaultEndpoints: []string{"http://node1:9200", "http://node2:9200", "http://node3:9200"}}
+	pool, err := newEndpointPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if e := pool.pick(0); e != pool.endpoints[i%3] {
+			t.Errorf("round_robin #%v picked %v", i, e.url)
+		}
+	}
+
+	pool.strategy = endpointSticky
+	for i := 0; i < 3; i++ {
+		if e := pool.pick(4); e != pool.endpoints[1] {
+			t.Errorf("sticky picked %v", e.url)
+		}
+	}
+	if e := pool.pick(-1); e != pool.endpoints[0] {
+		t.Errorf("sticky of warm-up picked %v", e.url)
+	}
+
+	pool.strategy = endpointRandom
+	initSeed(42)
+	var picked []*endpoint
+	for i := 0; i < 6; i++ {
+		picked = append(picked, pool.pick(1))
+	}
+	initSeed(42)
+	for i := 0; i < 6; i++ {
+		if e := pool.pick(1); e != picked[i] {
+			t.Errorf("random #%v of the same seed picked %v, expected %v", i, e.url, picked[i].url)
+		}
+	}
+
+	pool.strategy = endpointLeastInflight
+	pool.endpoints[0].inflight = 2
+	pool.endpoints[1].inflight = 1
+	pool.endpoints[2].inflight = 3
+	if e := pool.pick(0); e != pool.endpoints[1] {
+		t.Errorf("least_inflight picked %v", e.url)
+	}
+
+	config.EndpointStrategy = "unknown"
+	if _, err := newEndpointPool(config); err == nil {
+		t.Error("expected error of unknown strategy")
+	}
+}
+
+func TestEndpointEjection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + listener.Addr().String()
+	listener.Close()
+
+	pool, err := newEndpointPool(&RunnerConfig{
+		DefaultEndpoints:    []string{dead, server.URL},
+		EndpointMaxFailures: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &balancedClient{requestDoer: &fasthttp.Client{Dial: dialConn}, pool: pool}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	for i := 0; i < 10; i++ {
+		req.SetRequestURI(pool.pick(0).url + "/")
+		client.Do(req, resp)
+	}
+
+	if pool.endpoints[0].requests != 2 || pool.endpoints[0].errors != 2 || pool.endpoints[0].ejections != 1 {
+		t.Errorf("unexpected stats of the dead endpoint: %v requests, %v errors, %v ejections",
+			pool.endpoints[0].requests, pool.endpoints[0].errors, pool.endpoints[0].ejections)
+	}
+	if pool.endpoints[1].requests != 8 || pool.endpoints[1].statusCode[200] != 8 {
+		t.Errorf("unexpected stats of the live endpoint: %v requests, %v", pool.endpoints[1].requests, pool.endpoints[1].statusCode)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	escapeNone = "none"
+	escapeAuto = "auto"
+	escapeJSON = "json"
+	escapeURL  = "url"
+	escapeXML  = "xml"
+	escapeHTML = "html"
+	// Values in the path of urls
+	escapePath = "path"
+)
+
+// EscapeConfig escapes the values rendered into placeholders by where they
+// land.
+type EscapeConfig struct {
+	// auto (default, inferred from the Content-Type header or the body), json,
+	// url, xml, html or none, json only escapes values in JSON strings
+	Body string `config:"body"`
+	// url or none (default), values in the path are escaped as path segments
+	// and values in the query as query parameters, the scheme and host are
+	// kept
+	Url string `config:"url"`
+	// url, json, xml, html or none (default)
+	Header string `config:"header"`
+}
+
+func (c *EscapeConfig) init() error {
+	if c.Body == "" {
+		c.Body = escapeAuto
+	}
+	if c.Url == "" {
+		c.Url = escapeNone
+	}
+	if c.Header == "" {
+		c.Header = escapeNone
+	}
+	for _, mode := range []string{c.Body, c.Url, c.Header} {
+		switch mode {
+		case escapeNone, escapeAuto, escapeJSON, escapeURL, escapeXML, escapeHTML:
+		default:
+			return fmt.Errorf("unsupported escape [%s]", mode)
+		}
+	}
+	if c.Url != escapeNone && c.Url != escapeURL {
+		return fmt.Errorf("unsupported url escape [%s]", c.Url)
+	}
+	if c.Header == escapeAuto {
+		return fmt.Errorf("unsupported header escape [%s]", c.Header)
+	}
+	return nil
+}
+
+// placeholderEscapes is the escaping of each placeholder of a template in
+// order.
+type placeholderEscapes []string
+
+// newPlaceholderEscapes returns the escaping of the placeholders of s.
+func newPlaceholderEscapes(s string, mode string, isURL bool) placeholderEscapes {
+	if mode == escapeNone || mode == "" || !strings.Contains(s, "$[[") {
+		return nil
+	}
+	var escapes placeholderEscapes
+	// JSON strings
+	inString, escaped := false, false
+	// Parts of urls
+	inPath, inQuery := false, false
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$[[") {
+			end := strings.Index(s[i+3:], "]]")
+			if end < 0 {
+				break
+			}
+			escape := mode
+			switch {
+			case mode == escapeJSON && !inString:
+				escape = escapeNone
+			case isURL && inQuery:
+				escape = escapeURL
+			case isURL && inPath:
+				escape = escapePath
+			case isURL:
+				escape = escapeNone
+			}
+			escapes = append(escapes, escape)
+			i += end + 5
+			escaped = false
+			continue
+		}
+
+		c := s[i]
+		if isURL {
+			switch {
+			case strings.HasPrefix(s[i:], "://"):
+				i += 3
+				continue
+			case c == '/':
+				inPath = true
+			case c == '?' || c == '#':
+				inQuery = true
+			}
+		} else if mode == escapeJSON {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && inString:
+				escaped = true
+			case c == '"':
+				inString = !inString
+			case c == '\n':
+				inString = false
+			}
+		}
+		i++
+	}
+	return escapes
+}
+
+// tagFunc renders the placeholders of the template with escaped values, a
+// new one is needed for each execution.
+func (e placeholderEscapes) tagFunc(runtimeKV util.MapStr) func(w io.Writer, tag string) (int, error) {
+	i := 0
+	return func(w io.Writer, tag string) (int, error) {
+		variable := GetVariable(runtimeKV, tag)
+		if i < len(e) {
+			variable = escapeValue(e[i], variable)
+		}
+		i++
+		return w.Write(util.UnsafeStringToBytes(variable))
+	}
+}
+
+func escapeValue(mode string, s string) string {
+	switch mode {
+	case escapeJSON:
+		return jsonEscape(s)
+	case escapeURL:
+		return url.QueryEscape(s)
+	case escapePath:
+		return url.PathEscape(s)
+	case escapeXML, escapeHTML:
+		return html.EscapeString(s)
+	}
+	return s
+}
+
+// jsonEscape escapes s to be put in a JSON string.
+func jsonEscape(s string) string {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	escaped := strings.TrimSuffix(buf.String(), "\n")
+	return escaped[1 : len(escaped)-1]
+}
+
+// escapeOfContentType infers the escaping of the body by the Content-Type.
+func escapeOfContentType(contentType string) string {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		return escapeJSON
+	case strings.Contains(contentType, "x-www-form-urlencoded"):
+		return escapeURL
+	case strings.Contains(contentType, "html"):
+		return escapeHTML
+	case strings.Contains(contentType, "xml"):
+		return escapeXML
+	}
+	return escapeNone
+}
+
+// initEscapes resolves the escaping of the placeholders of the request, the
+// escape of the request overrides the default of the runner.
+func (req *Request) initEscapes(defaultEscape *EscapeConfig) error {
+	escape := req.Escape
+	if escape == nil {
+		escape = defaultEscape
+	} else if err := escape.init(); err != nil {
+		return err
+	}
+	if escape == nil {
+		return nil
+	}
+
+	req.bodyEscape = escape.Body
+	if req.bodyEscape == escapeAuto {
+		req.bodyEscape = escapeNone
+		contentType := ""
+		for _, headers := range req.Headers {
+			for k, v := range headers {
+				if strings.EqualFold(k, fasthttp.HeaderContentType) {
+					contentType = v
+				}
+			}
+		}
+		body := strings.TrimSpace(req.Body)
+		switch {
+		case contentType != "":
+			req.bodyEscape = escapeOfContentType(contentType)
+		case strings.HasPrefix(body, "{") || strings.HasPrefix(body, "["):
+			req.bodyEscape = escapeJSON
+		case strings.HasPrefix(body, "<"):
+			req.bodyEscape = escapeXML
+		}
+	}
+	req.bodyEscapes = newPlaceholderEscapes(req.Body, req.bodyEscape, false)
+	req.urlEscapes = newPlaceholderEscapes(req.Url, escape.Url, true)
+	req.headerEscapes = map[string]placeholderEscapes{}
+	for _, headers := range req.Headers {
+		for k, v := range headers {
+			req.headerEscapes[k] = newPlaceholderEscapes(v, escape.Header, false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"infini.sh/framework/core/util"
+)
+
+func TestPlaceholderEscapes(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		mode     string
+		isURL    bool
+		expected placeholderEscapes
+	}{
+		{`{"a": "$[[x]]", "b": $[[y]], "c": "\"$[[x]]", "d": ["$[[y]]"]}`, escapeJSON, false, placeholderEscapes{escapeJSON, escapeNone, escapeJSON, escapeJSON}},
+		{"{\"a\": \"$[[x]]\n$[[y]]", escapeJSON, false, placeholderEscapes{escapeJSON, escapeNone}},
+		{"$[[endpoint]]/$[[index]]/_search?q=$[[q]]", escapeURL, true, placeholderEscapes{escapeNone, escapePath, escapeURL}},
+		{"http://$[[host]]:9200/$[[index]]", escapeURL, true, placeholderEscapes{escapeNone, escapePath}},
+		{"<a>$[[x]]</a>$[[y]]", escapeXML, false, placeholderEscapes{escapeXML, escapeXML}},
+		{`{"a": "$[[x]]"}`, escapeNone, false, nil},
+	} {
+		if escapes := newPlaceholderEscapes(c.s, c.mode, c.isURL); !reflect.DeepEqual(escapes, c.expected) {
+			t.Errorf("[%s]: expected %v, got %v", c.s, c.expected, escapes)
+		}
+	}
+}
+
+func TestEscapedRequest(t *testing.T) {
+	config := &LoaderConfig{
+		Variable: []Variable{{Name: "ip", Type: "list", Data: []string{`10.0.0.1 "a\b" <c>`}}},
+		Requests: []RequestItem{
+			{Request: &Request{Method: "POST", Url: "/$[[ip]]/_doc?q=$[[ip]]", Body: "{\"ip\": \"$[[ip]]\", \"tags\": $[[tags]]}\n", Escape: &EscapeConfig{Url: escapeURL}}},
+			{Request: &Request{Method: "POST", Url: "/_doc", Body: "ip=$[[ip]]", Headers: []map[string]string{{"Content-Type": "application/x-www-form-urlencoded"}}}},
+			{Request: &Request{Method: "POST", Url: "/_doc", Body: "<ip>$[[ip]]</ip>", Escape: &EscapeConfig{Body: escapeNone}}},
+		},
+		RunnerConfig: RunnerConfig{DefaultEscape: &EscapeConfig{}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := config.Requests[0].Request
+	runtimeKV := util.MapStr{"tags": `["a", "b"]`}
+	buffer := &bytes.Buffer{}
+	writeBody(buffer, req.Body, req.bodyTemplate, req.bodyEscapes, 2, nil, runtimeKV)
+	for _, line := range bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n")) {
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(line, &doc); err != nil {
+			t.Fatalf("invalid json [%s]: %v", line, err)
+		}
+		if doc["ip"] != `10.0.0.1 "a\b" <c>` || len(doc["tags"].([]interface{})) != 2 {
+			t.Errorf("unexpected doc: %v", doc)
+		}
+	}
+	if url := req.urlTemplate.ExecuteFuncString(req.urlEscapes.tagFunc(runtimeKV)); url != "/10.0.0.1%20%22a%5Cb%22%20%3Cc%3E/_doc?q=10.0.0.1+%22a%5Cb%22+%3Cc%3E" {
+		t.Errorf("unexpected url: %s", url)
+	}
+
+	if req = config.Requests[1].Request; req.bodyEscape != escapeURL {
+		t.Errorf("expected escape inferred from Content-Type, got %s", req.bodyEscape)
+	}
+	if req = config.Requests[2].Request; req.bodyEscape != escapeNone || req.bodyEscapes != nil {
+		t.Errorf("expected no escape, got %s", req.bodyEscape)
+	}
+
+	for _, escape := range []*EscapeConfig{{Body: "base64"}, {Url: escapeJSON}, {Header: escapeAuto}} {
+		if err := escape.init(); err == nil {
+			t.Errorf("expected error of %+v", escape)
+		}
+	}
+}
@@ -0,0 +1,685 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+)
+
+// Placeholders like `$[[upper(user)]]` or `$[[id * 10 + 5]]` are evaluated as
+// expressions, plain variable names are resolved by GetVariable directly.
+//
+//	expr    = term {("+" | "-") term}
+//	term    = unary {("*" | "/" | "%") unary}
+//	unary   = "-" unary | primary
+//	primary = number | string | name | name "(" [expr {"," expr}] ")" | "(" expr ")"
+//
+// Names may contain `-`, so `-` needs spaces around it as an operator.
+
+const invalidExpression = "invalid_expression"
+
+// isPlainTag checks if the placeholder is a variable name.
+func isPlainTag(tag string) bool {
+	return !strings.ContainsAny(tag, "()+*/%,\"' \t\r\n")
+}
+
+// exprValue is a string or a number.
+type exprValue struct {
+	str   string
+	num   bool
+	isInt bool
+	i     int64
+	f     float64
+}
+
+func stringValue(s string) exprValue {
+	return exprValue{str: s}
+}
+
+func intValue(i int64) exprValue {
+	return exprValue{num: true, isInt: true, i: i}
+}
+
+func floatValue(f float64) exprValue {
+	return exprValue{num: true, f: f}
+}
+
+func (v exprValue) String() string {
+	if !v.num {
+		return v.str
+	}
+	if v.isInt {
+		return strconv.FormatInt(v.i, 10)
+	}
+	return strconv.FormatFloat(v.f, 'f', -1, 64)
+}
+
+func (v exprValue) float() float64 {
+	if v.isInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// number converts the value to a number.
+func (v exprValue) number() (exprValue, error) {
+	if v.num {
+		return v, nil
+	}
+	s := strings.TrimSpace(v.str)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return intValue(i), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return floatValue(f), nil
+	}
+	return v, fmt.Errorf("[%s] is not a number", v.str)
+}
+
+type exprNode interface {
+	eval(runtimeKV util.MapStr) (exprValue, error)
+}
+
+type literalNode struct {
+	value exprValue
+}
+
+func (n *literalNode) eval(util.MapStr) (exprValue, error) {
+	return n.value, nil
+}
+
+type nameNode struct {
+	name string
+}
+
+func (n *nameNode) eval(runtimeKV util.MapStr) (exprValue, error) {
+	return stringValue(GetVariable(runtimeKV, n.name)), nil
+}
+
+func hasVariable(runtimeKV util.MapStr, name string) bool {
+	if runtimeKV != nil {
+		if _, err := runtimeKV.GetValue(name); err == nil {
+			return true
+		}
+	}
+	_, ok := variables[name]
+	return ok
+}
+
+type negNode struct {
+	x exprNode
+}
+
+func (n *negNode) eval(runtimeKV util.MapStr) (exprValue, error) {
+	v, err := n.x.eval(runtimeKV)
+	if err == nil {
+		v, err = v.number()
+	}
+	if err != nil {
+		return v, err
+	}
+	if v.isInt {
+		return intValue(-v.i), nil
+	}
+	return floatValue(-v.f), nil
+}
+
+type binaryNode struct {
+	op   byte
+	l, r exprNode
+}
+
+func (n *binaryNode) eval(runtimeKV util.MapStr) (exprValue, error) {
+	l, err := n.l.eval(runtimeKV)
+	if err != nil {
+		return l, err
+	}
+	r, err := n.r.eval(runtimeKV)
+	if err != nil {
+		return r, err
+	}
+	ln, lErr := l.number()
+	rn, rErr := r.number()
+	if lErr != nil || rErr != nil {
+		// `+` concatenates strings
+		if n.op == '+' {
+			return stringValue(l.String() + r.String()), nil
+		}
+		if lErr != nil {
+			return l, lErr
+		}
+		return r, rErr
+	}
+
+	if ln.isInt && rn.isInt {
+		a, b := ln.i, rn.i
+		switch n.op {
+		case '+':
+			return intValue(a + b), nil
+		case '-':
+			return intValue(a - b), nil
+		case '*':
+			return intValue(a * b), nil
+		case '/':
+			if b == 0 {
+				return ln, fmt.Errorf("division by zero")
+			}
+			if a%b == 0 {
+				return intValue(a / b), nil
+			}
+		case '%':
+			if b == 0 {
+				return ln, fmt.Errorf("division by zero")
+			}
+			return intValue(a % b), nil
+		}
+	}
+
+	a, b := ln.float(), rn.float()
+	switch n.op {
+	case '+':
+		return floatValue(a + b), nil
+	case '-':
+		return floatValue(a - b), nil
+	case '*':
+		return floatValue(a * b), nil
+	case '/':
+		if b == 0 {
+			return ln, fmt.Errorf("division by zero")
+		}
+		return floatValue(a / b), nil
+	case '%':
+		if b == 0 {
+			return ln, fmt.Errorf("division by zero")
+		}
+		return floatValue(math.Mod(a, b)), nil
+	}
+	return ln, fmt.Errorf("unknown operator [%c]", n.op)
+}
+
+type callNode struct {
+	fn   *exprFunc
+	args []exprNode
+}
+
+func (n *callNode) eval(runtimeKV util.MapStr) (exprValue, error) {
+	// default returns the first argument that is found and not empty
+	if n.fn.name == "default" {
+		var v exprValue
+		for i, arg := range n.args {
+			if name, ok := arg.(*nameNode); ok && i < len(n.args)-1 && !hasVariable(runtimeKV, name.name) {
+				continue
+			}
+			var err error
+			if v, err = arg.eval(runtimeKV); err != nil {
+				return v, err
+			}
+			if v.String() != "" {
+				return v, nil
+			}
+		}
+		return v, nil
+	}
+
+	args := make([]exprValue, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(runtimeKV)
+		if err != nil {
+			return v, err
+		}
+		args[i] = v
+	}
+	return n.fn.call(args)
+}
+
+type exprFunc struct {
+	name    string
+	minArgs int
+	// -1 for any number of arguments
+	maxArgs int
+	call    func(args []exprValue) (exprValue, error)
+}
+
+func stringFunc(name string, f func(s string) string) *exprFunc {
+	return &exprFunc{name: name, minArgs: 1, maxArgs: 1, call: func(args []exprValue) (exprValue, error) {
+		return stringValue(f(args[0].String())), nil
+	}}
+}
+
+func hashFunc(name string, f func(b []byte) []byte) *exprFunc {
+	return stringFunc(name, func(s string) string {
+		return hex.EncodeToString(f([]byte(s)))
+	})
+}
+
+func numberFunc(name string, f func(v exprValue) exprValue) *exprFunc {
+	return &exprFunc{name: name, minArgs: 1, maxArgs: 1, call: func(args []exprValue) (exprValue, error) {
+		v, err := args[0].number()
+		if err != nil {
+			return v, err
+		}
+		return f(v), nil
+	}}
+}
+
+func intArg(v exprValue) (int, error) {
+	n, err := v.number()
+	if err != nil {
+		return 0, err
+	}
+	if !n.isInt {
+		return int(n.f), nil
+	}
+	return int(n.i), nil
+}
+
+var exprFuncs = map[string]*exprFunc{}
+
+func init() {
+	for _, f := range []*exprFunc{
+		stringFunc("upper", strings.ToUpper),
+		stringFunc("lower", strings.ToLower),
+		stringFunc("trim", strings.TrimSpace),
+		stringFunc("base64", func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		}),
+		stringFunc("url_encode", url.QueryEscape),
+		stringFunc("json_escape", jsonEscape),
+		hashFunc("md5", func(b []byte) []byte {
+			sum := md5.Sum(b)
+			return sum[:]
+		}),
+		hashFunc("sha1", func(b []byte) []byte {
+			sum := sha1.Sum(b)
+			return sum[:]
+		}),
+		hashFunc("sha256", func(b []byte) []byte {
+			sum := sha256.Sum256(b)
+			return sum[:]
+		}),
+		{name: "base64_decode", minArgs: 1, maxArgs: 1, call: func(args []exprValue) (exprValue, error) {
+			b, err := base64.StdEncoding.DecodeString(args[0].String())
+			return stringValue(string(b)), err
+		}},
+		{name: "len", minArgs: 1, maxArgs: 1, call: func(args []exprValue) (exprValue, error) {
+			return intValue(int64(len([]rune(args[0].String())))), nil
+		}},
+		{name: "substr", minArgs: 2, maxArgs: 3, call: func(args []exprValue) (exprValue, error) {
+			s := []rune(args[0].String())
+			start, err := intArg(args[1])
+			if err != nil {
+				return args[1], err
+			}
+			end := len(s)
+			if len(args) > 2 {
+				length, err := intArg(args[2])
+				if err != nil {
+					return args[2], err
+				}
+				end = start + length
+			}
+			if start < 0 {
+				start = 0
+			}
+			if start > len(s) {
+				start = len(s)
+			}
+			if end > len(s) {
+				end = len(s)
+			}
+			if end < start {
+				end = start
+			}
+			return stringValue(string(s[start:end])), nil
+		}},
+		{name: "replace", minArgs: 3, maxArgs: 3, call: func(args []exprValue) (exprValue, error) {
+			return stringValue(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String())), nil
+		}},
+		{name: "concat", minArgs: 1, maxArgs: -1, call: func(args []exprValue) (exprValue, error) {
+			var s strings.Builder
+			for _, arg := range args {
+				s.WriteString(arg.String())
+			}
+			return stringValue(s.String()), nil
+		}},
+		{name: "default", minArgs: 2, maxArgs: -1},
+		numberFunc("int", func(v exprValue) exprValue {
+			if v.isInt {
+				return v
+			}
+			return intValue(int64(v.f))
+		}),
+		numberFunc("abs", func(v exprValue) exprValue {
+			if v.isInt {
+				if v.i < 0 {
+					return intValue(-v.i)
+				}
+				return v
+			}
+			return floatValue(math.Abs(v.f))
+		}),
+		{name: "round", minArgs: 1, maxArgs: 2, call: func(args []exprValue) (exprValue, error) {
+			v, err := args[0].number()
+			if err != nil || v.isInt {
+				return v, err
+			}
+			precision := 0
+			if len(args) > 1 {
+				if precision, err = intArg(args[1]); err != nil {
+					return args[1], err
+				}
+			}
+			if precision <= 0 {
+				return intValue(int64(math.Round(v.f))), nil
+			}
+			p := math.Pow10(precision)
+			return floatValue(math.Round(v.f*p) / p), nil
+		}},
+	} {
+		exprFuncs[f.name] = f
+	}
+}
+
+type exprToken struct {
+	kind byte // 'n' number, 's' string, 'i' name, or the operator
+	text string
+}
+
+func tokenize(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*/%(),", r):
+			tokens = append(tokens, exprToken{kind: byte(r), text: string(r)})
+			i++
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: 'n', text: string(runes[start:i])})
+		case r == '"' || r == '\'':
+			var str strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						str.WriteRune('\n')
+					case 't':
+						str.WriteRune('\t')
+					default:
+						str.WriteRune(runes[i])
+					}
+					continue
+				}
+				str.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: 's', text: str.String()})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: 'i', text: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected character [%c]", r)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() byte {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return 0
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(kind byte) error {
+	if p.peek() != kind {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) unexpected() error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("unexpected end")
+	}
+	return fmt.Errorf("unexpected [%s]", p.tokens[p.pos].text)
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	l, err := p.term()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.next().kind
+		var r exprNode
+		if r, err = p.term(); err == nil {
+			l = &binaryNode{op: op, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	l, err := p.unary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/' || p.peek() == '%') {
+		op := p.next().kind
+		var r exprNode
+		if r, err = p.unary(); err == nil {
+			l = &binaryNode{op: op, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.unary()
+		return &negNode{x: x}, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	switch p.peek() {
+	case 'n':
+		v, err := stringValue(p.next().text).number()
+		return &literalNode{value: v}, err
+	case 's':
+		return &literalNode{value: stringValue(p.next().text)}, nil
+	case '(':
+		p.pos++
+		x, err := p.expr()
+		if err == nil {
+			err = p.expect(')')
+		}
+		return x, err
+	case 'i':
+		name := p.next().text
+		if p.peek() != '(' {
+			return &nameNode{name: name}, nil
+		}
+		p.pos++
+		fn, ok := exprFuncs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function [%s]", name)
+		}
+		call := &callNode{fn: fn}
+		for p.peek() != ')' {
+			if len(call.args) > 0 {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.pos++
+		if len(call.args) < fn.minArgs || fn.maxArgs >= 0 && len(call.args) > fn.maxArgs {
+			return nil, fmt.Errorf("wrong number of arguments of [%s]", name)
+		}
+		return call, nil
+	}
+	return nil, p.unexpected()
+}
+
+type compiledExpression struct {
+	root exprNode
+	err  error
+	// Evaluation errors are warned once
+	warned int32
+}
+
+// isConstant checks if the node has no names, so that it's evaluated the
+// same way every time.
+func isConstant(node exprNode) bool {
+	switch n := node.(type) {
+	case *literalNode:
+		return true
+	case *negNode:
+		return isConstant(n.x)
+	case *binaryNode:
+		return isConstant(n.l) && isConstant(n.r)
+	case *callNode:
+		for _, arg := range n.args {
+			if !isConstant(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// expressions caches the compiled placeholders.
+var expressions sync.Map
+
+func compileExpression(tag string) *compiledExpression {
+	if v, ok := expressions.Load(tag); ok {
+		return v.(*compiledExpression)
+	}
+	e := &compiledExpression{}
+	tokens, err := tokenize(tag)
+	if err == nil {
+		p := &exprParser{tokens: tokens}
+		if e.root, err = p.expr(); err == nil && p.pos < len(p.tokens) {
+			err = p.unexpected()
+		}
+	}
+	if err == nil && isConstant(e.root) {
+		// Constant expressions fail the same way on every request
+		_, err = e.root.eval(nil)
+	}
+	if err != nil {
+		e.err = fmt.Errorf("invalid expression [%s]: %v", tag, err)
+	}
+	v, _ := expressions.LoadOrStore(tag, e)
+	return v.(*compiledExpression)
+}
+
+// compileExpressions compiles the expressions in placeholders of the
+// template string.
+func compileExpressions(s string) error {
+	for {
+		start := strings.Index(s, "$[[")
+		if start < 0 {
+			return nil
+		}
+		s = s[start+3:]
+		end := strings.Index(s, "]]")
+		if end < 0 {
+			return nil
+		}
+		if tag := s[:end]; !isPlainTag(tag) {
+			if e := compileExpression(tag); e.err != nil {
+				return e.err
+			}
+		}
+		s = s[end+2:]
+	}
+}
+
+func evalExpression(runtimeKV util.MapStr, tag string) string {
+	e := compileExpression(tag)
+	err := e.err
+	var v exprValue
+	if err == nil {
+		if v, err = e.root.eval(runtimeKV); err == nil {
+			return v.String()
+		}
+		err = fmt.Errorf("failed to evaluate [%s]: %v", tag, err)
+	}
+	if atomic.CompareAndSwapInt32(&e.warned, 0, 1) {
+		log.Warnf("%v, rendered as [%s], later errors of the expression are logged at debug level", err, invalidExpression)
+	} else {
+		log.Debug(err)
+	}
+	return invalidExpression
+}
@@ -0,0 +1,88 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+
+	"infini.sh/framework/core/util"
+)
+
+func TestExpression(t *testing.T) {
+	runtimeKV := util.MapStr{
+		"user":    "Medcl",
+		"id":      "42",
+		"ts":      "1700000000",
+		"price":   "9.5",
+		"ip":      `10.0.0.1 "a\b"`,
+		"user-id": "u1",
+		"env":     util.MapStr{"X": "x"},
+		"uuid":    "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+	}
+	for tag, expected := range map[string]string{
+		"upper(user)":               "MEDCL",
+		"lower( user )":             "medcl",
+		"base64(user)":              "TWVkY2w=",
+		"base64_decode('TWVkY2w=')": "Medcl",
+		"sha256(id)":                "73475cb40a568e8da8a045ced110137e159f890ac4da883b6b17dc651b3a8049",
+		"md5('')":                   "d41d8cd98f00b204e9800998ecf8427e",
+		"id * 10 + 5":               "425",
+		"(id + 8) * 2":              "100",
+		"ts - 3600":                 "1699996400",
+		"-id % 5":                   "-2",
+		"id / 4":                    "10.5",
+		"id / 2":                    "21",
+		"price * 2":                 "19",
+		"round(price / 3, 2)":       "3.17",
+		"int(price)":                "9",
+		"json_escape(ip)":           `10.0.0.1 \"a\\b\"`,
+		`default(env.X, "a")`:       "x",
+		`default(env.Y, "a")`:       "a",
+		`default(missing, "", 1)`:   "1",
+		"substr(uuid,0,8)":          "6ba7b810",
+		"substr(user, 3)":           "cl",
+		"substr(user, 2, 100)":      "dcl",
+		"len(user)":                 "5",
+		"user + '-' + id":           "Medcl-42",
+		"concat(user, user-id)":     "Medclu1",
+		"replace(uuid, '-', '')":    "6ba7b8109dad11d180b400c04fd430c8",
+		"url_encode('a b&c')":       "a+b%26c",
+		"user * 2":                  invalidExpression,
+		"id / 0":                    invalidExpression,
+		"unknown(user)":             invalidExpression,
+	} {
+		if v := GetVariable(runtimeKV, tag); v != expected {
+			t.Errorf("[%s]: expected %s, got %s", tag, expected, v)
+		}
+	}
+
+	for _, tag := range []string{"upper(user", "foo(user)", "upper(user, id)", "'abc", "id +", "id id", "id # 2", "1 / 0", "'a' * 2"} {
+		if err := compileExpressions("x$[[" + tag + "]]y"); err == nil {
+			t.Errorf("expected error of [%s]", tag)
+		}
+	}
+	if err := compileExpressions("$[[user]] $[[upper(user)]] $[[ts - 3600]]"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	localeEN = "en"
+	localeZH = "zh"
+)
+
+// fakerTypes are the variable types generated by faker.
+var fakerTypes = map[string]bool{
+	"person_name":     true,
+	"email":           true,
+	"username":        true,
+	"phone":           true,
+	"address":         true,
+	"city":            true,
+	"country":         true,
+	"company":         true,
+	"lorem_words":     true,
+	"lorem_sentences": true,
+	"url":             true,
+	"user_agent":      true,
+	"ipv4":            true,
+	"ipv6":            true,
+}
+
+// faker generates realistic values of a variable, values of the same seed
+// are generated in the same sequence.
+type faker struct {
+	locale *fakerLocale
+
+	seed int64
+	lock sync.Mutex
+	rand *rand.Rand
+	vus  map[int]*faker
+}
+
+func newFaker(locale string, seed int64) (*faker, error) {
+	if locale == "" {
+		locale = localeEN
+	}
+	l, ok := fakerLocales[locale]
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale [%s]", locale)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faker{locale: l, seed: seed, rand: rand.New(rand.NewSource(seed)), vus: map[int]*faker{}}, nil
+}
+
+// forVU returns the faker of the VU, which is seeded by the seed and the VU,
+// so values of each VU are generated in the same sequence.
+func (f *faker) forVU(vu int) *faker {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	v, ok := f.vus[vu]
+	if !ok {
+		v, _ = newFaker(f.locale.name, mixSeed(f.seed, uint64(int64(vu))))
+		f.vus[vu] = v
+	}
+	return v
+}
+
+func (f *faker) pick(values []string) string {
+	return values[f.rand.Intn(len(values))]
+}
+
+func (f *faker) digits(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + f.rand.Intn(10))
+	}
+	return string(b)
+}
+
+// value generates the value of the variable type, size is the number of
+// words or sentences of lorem types.
+func (f *faker) value(typ string, size int) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	l := f.locale
+	switch typ {
+	case "person_name":
+		if l.name == localeZH {
+			return f.pick(l.lastNames) + f.pick(l.firstNames)
+		}
+		return f.pick(l.firstNames) + " " + f.pick(l.lastNames)
+	case "username":
+		return f.username()
+	case "email":
+		return f.username() + "@" + f.pick(emailDomains)
+	case "phone":
+		if l.name == localeZH {
+			return f.pick(zhPhonePrefixes) + f.digits(8)
+		}
+		return fmt.Sprintf("(%d%s) %d%s-%s", 2+f.rand.Intn(8), f.digits(2), 2+f.rand.Intn(8), f.digits(2), f.digits(4))
+	case "address":
+		if l.name == localeZH {
+			return f.pick(l.cities) + f.pick(zhDistricts) + f.pick(l.streets) + fmt.Sprintf("%d号", 1+f.rand.Intn(999))
+		}
+		return fmt.Sprintf("%d %s %s, %s", 1+f.rand.Intn(9999), f.pick(l.streets), f.pick(enStreetSuffixes), f.pick(l.cities))
+	case "city":
+		return f.pick(l.cities)
+	case "country":
+		return f.pick(l.countries)
+	case "company":
+		if l.name == localeZH {
+			return f.pick(l.cities) + f.pick(l.companyWords) + f.pick(l.companySuffixes)
+		}
+		return f.pick(l.lastNames) + " " + f.pick(l.companyWords) + " " + f.pick(l.companySuffixes)
+	case "lorem_words":
+		return strings.Join(f.words(size), l.wordSeparator)
+	case "lorem_sentences":
+		if size <= 0 {
+			size = 1
+		}
+		sentences := make([]string, size)
+		for i := range sentences {
+			sentences[i] = f.sentence()
+		}
+		return strings.Join(sentences, l.sentenceSeparator)
+	case "url":
+		return fmt.Sprintf("https://www.%s.%s/%s/%s", f.pick(enLoremWords), f.pick(urlTLDs), f.pick(enLoremWords), f.pick(enLoremWords))
+	case "user_agent":
+		return f.pick(userAgents)
+	case "ipv4":
+		return net.IPv4(byte(1+f.rand.Intn(223)), byte(f.rand.Intn(256)), byte(f.rand.Intn(256)), byte(1+f.rand.Intn(254))).String()
+	case "ipv6":
+		ip := make(net.IP, net.IPv6len)
+		f.rand.Read(ip)
+		// Global unicast addresses, 2000::/3
+		ip[0] = 0x20 | ip[0]&0x1f
+		return ip.String()
+	}
+	return "invalid_variable_type"
+}
+
+// username is romanized for all locales.
+func (f *faker) username() string {
+	first := strings.ToLower(f.pick(enFirstNames))
+	last := strings.ToLower(f.pick(enLastNames))
+	switch f.rand.Intn(3) {
+	case 0:
+		return first + "." + last
+	case 1:
+		return first + "_" + last + f.digits(2)
+	default:
+		return first[:1] + last + f.digits(3)
+	}
+}
+
+func (f *faker) words(n int) []string {
+	if n <= 0 {
+		n = 1
+	}
+	words := make([]string, n)
+	for i := range words {
+		words[i] = f.pick(f.locale.loremWords)
+	}
+	return words
+}
+
+func (f *faker) sentence() string {
+	l := f.locale
+	words := f.words(4 + f.rand.Intn(8))
+	if l.name == localeZH {
+		return strings.Join(words, "") + "。"
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	return strings.Join(words, " ") + "."
+}
+
+type fakerLocale struct {
+	name              string
+	firstNames        []string
+	lastNames         []string
+	streets           []string
+	cities            []string
+	countries         []string
+	companyWords      []string
+	companySuffixes   []string
+	loremWords        []string
+	wordSeparator     string
+	sentenceSeparator string
+}
+
+var fakerLocales = map[string]*fakerLocale{
+	localeEN: {
+		name:              localeEN,
+		firstNames:        enFirstNames,
+		lastNames:         enLastNames,
+		streets:           []string{"Main", "Oak", "Pine", "Maple", "Cedar", "Elm", "Washington", "Lake", "Hill", "Park", "Sunset", "Church", "River", "Highland", "Franklin", "Jackson", "Lincoln", "Madison", "Spring", "Walnut"},
+		cities:            []string{"New York", "Los Angeles", "Chicago", "Houston", "Phoenix", "Philadelphia", "San Antonio", "San Diego", "Dallas", "Austin", "Seattle", "Denver", "Boston", "Portland", "Atlanta", "Miami", "London", "Manchester", "Toronto", "Sydney", "Melbourne", "Auckland", "Dublin", "Vancouver"},
+		countries:         []string{"United States", "United Kingdom", "Canada", "Australia", "New Zealand", "Ireland", "Germany", "France", "Japan", "China", "India", "Brazil", "Mexico", "Spain", "Italy", "Netherlands", "Sweden", "Singapore", "South Korea", "South Africa"},
+		companyWords:      []string{"Technologies", "Systems", "Solutions", "Software", "Labs", "Networks", "Data", "Logistics", "Consulting", "Holdings", "Industries", "Media", "Energy", "Analytics", "Dynamics"},
+		companySuffixes:   []string{"Inc.", "LLC", "Ltd.", "Corp.", "Group", "Co."},
+		loremWords:        enLoremWords,
+		wordSeparator:     " ",
+		sentenceSeparator: " ",
+	},
+	localeZH: {
+		name:              localeZH,
+		firstNames:        []string{"伟", "芳", "娜", "敏", "静", "丽", "强", "磊", "军", "洋", "勇", "艳", "杰", "娟", "涛", "明", "超", "秀英", "霞", "平", "刚", "桂英", "建华", "晓东", "子轩", "浩然", "雨涵", "欣怡", "梓涵", "思远", "文博", "嘉怡"},
+		lastNames:         []string{"王", "李", "张", "刘", "陈", "杨", "黄", "赵", "吴", "周", "徐", "孙", "马", "朱", "胡", "郭", "何", "高", "林", "罗", "郑", "梁", "谢", "宋", "唐", "许", "韩", "冯", "邓", "曹", "欧阳", "司马"},
+		streets:           []string{"人民路", "中山路", "解放路", "建设路", "和平路", "胜利路", "新华路", "长江路", "黄河路", "南京路", "文化路", "学院路", "科技路", "滨江大道", "世纪大道", "建国路"},
+		cities:            []string{"北京市", "上海市", "广州市", "深圳市", "杭州市", "南京市", "成都市", "武汉市", "西安市", "重庆市", "天津市", "苏州市", "长沙市", "郑州市", "青岛市", "厦门市", "合肥市", "昆明市"},
+		countries:         []string{"中国", "美国", "英国", "法国", "德国", "日本", "韩国", "新加坡", "加拿大", "澳大利亚", "新西兰", "俄罗斯", "印度", "巴西", "意大利", "西班牙", "荷兰", "瑞典"},
+		companyWords:      []string{"星辰", "华信", "云图", "极光", "未来", "智联", "创新", "远航", "恒通", "天成", "博远", "东方", "盛世", "鼎新"},
+		companySuffixes:   []string{"科技有限公司", "信息技术有限公司", "网络科技有限公司", "数据服务有限公司", "软件股份有限公司", "集团有限公司"},
+		loremWords:        []string{"我们", "数据", "系统", "用户", "可以", "通过", "进行", "搜索", "服务", "性能", "快速", "稳定", "支持", "提供", "查询", "结果", "分析", "实时", "索引", "文档", "集群", "节点", "网络", "请求", "处理", "能力", "方案", "简单", "高效", "安全", "应用", "平台"},
+		wordSeparator:     "",
+		sentenceSeparator: "",
+	},
+}
+
+var enFirstNames = []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth", "David", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Charles", "Karen", "Daniel", "Nancy", "Matthew", "Lisa", "Anthony", "Emily", "Mark", "Olivia", "Steven", "Emma", "Andrew", "Sophia"}
+
+var enLastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez", "Wilson", "Anderson", "Taylor", "Thomas", "Moore", "Jackson", "Martin", "Lee", "Thompson", "White", "Harris", "Clark", "Lewis", "Robinson", "Walker", "Young", "Allen", "King", "Wright", "Scott", "Hill", "Green"}
+
+var enStreetSuffixes = []string{"Street", "Avenue", "Road", "Boulevard", "Lane", "Drive", "Court", "Way", "Place"}
+
+var enLoremWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim", "ad", "minim", "veniam", "quis", "nostrud", "exercitation", "ullamco", "laboris", "nisi", "aliquip", "ex", "ea", "commodo", "consequat", "duis", "aute", "irure", "in", "reprehenderit", "voluptate", "velit", "esse", "cillum", "fugiat", "nulla", "pariatur", "excepteur", "sint", "occaecat", "cupidatat", "non", "proident", "sunt", "culpa", "qui", "officia", "deserunt", "mollit", "anim", "id", "est", "laborum"}
+
+var zhDistricts = []string{"朝阳区", "海淀区", "浦东新区", "徐汇区", "天河区", "南山区", "西湖区", "鼓楼区", "武侯区", "高新区", "经济开发区", "滨江区"}
+
+var zhPhonePrefixes = []string{"130", "131", "132", "135", "136", "137", "138", "139", "150", "151", "152", "157", "158", "159", "166", "176", "177", "178", "180", "181", "186", "187", "188", "189", "199"}
+
+var emailDomains = []string{"gmail.com", "yahoo.com", "outlook.com", "hotmail.com", "icloud.com", "example.com", "example.org", "qq.com", "163.com"}
+
+var urlTLDs = []string{"com", "org", "net", "io", "info", "dev", "cn"}
+
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+	"curl/8.7.1",
+}
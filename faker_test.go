@@ -0,0 +1,104 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFakerSeed(t *testing.T) {
+	for typ := range fakerTypes {
+		a, _ := newFaker(localeEN, 42)
+		b, _ := newFaker(localeEN, 42)
+		for i := 0; i < 10; i++ {
+			if x, y := a.value(typ, 3), b.value(typ, 3); x != y {
+				t.Errorf("[%s] values of the same seed differ: %s, %s", typ, x, y)
+			}
+		}
+	}
+}
+
+func TestFakerValues(t *testing.T) {
+	f, _ := newFaker(localeEN, 1)
+	email := regexp.MustCompile(`^[a-z]+[._]?[a-z]+\d*@[a-z0-9.]+$`)
+	for i := 0; i < 100; i++ {
+		if v := f.value("email", 0); !email.MatchString(v) {
+			t.Errorf("invalid email: %s", v)
+		}
+		if v := net.ParseIP(f.value("ipv4", 0)); v == nil || v.To4() == nil {
+			t.Errorf("invalid ipv4: %v", v)
+		}
+		if v := net.ParseIP(f.value("ipv6", 0)); v == nil || v.To4() != nil || !v.IsGlobalUnicast() {
+			t.Errorf("invalid ipv6: %v", v)
+		}
+		if v := f.value("lorem_words", 5); len(strings.Fields(v)) != 5 {
+			t.Errorf("expected 5 words: %s", v)
+		}
+		if v := f.value("lorem_sentences", 3); strings.Count(v, ".") != 3 {
+			t.Errorf("expected 3 sentences: %s", v)
+		}
+		if v := f.value("url", 0); !strings.HasPrefix(v, "https://www.") {
+			t.Errorf("invalid url: %s", v)
+		}
+	}
+
+	f, _ = newFaker(localeZH, 1)
+	for i := 0; i < 100; i++ {
+		if v := f.value("person_name", 0); utf8.RuneCountInString(v) < 2 || strings.Contains(v, " ") {
+			t.Errorf("invalid zh name: %s", v)
+		}
+		if v := f.value("phone", 0); !regexp.MustCompile(`^1\d{10}$`).MatchString(v) {
+			t.Errorf("invalid zh phone: %s", v)
+		}
+		if v := f.value("lorem_sentences", 2); strings.Count(v, "。") != 2 {
+			t.Errorf("expected 2 sentences: %s", v)
+		}
+	}
+}
+
+func TestFakerVariable(t *testing.T) {
+	config := &LoaderConfig{Variable: []Variable{
+		{Name: "user", Type: "person_name", Locale: localeZH, Seed: 7},
+		{Name: "agent", Type: "user_agent"},
+	}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := newFaker(localeZH, 7)
+	if v := getVariable("user", 0); v != expected.forVU(0).value("person_name", 0) {
+		t.Errorf("unexpected value: %s", v)
+	}
+	if v := getVariable("agent", 0); !strings.Contains(v, "/") {
+		t.Errorf("unexpected user agent: %s", v)
+	}
+
+	config = &LoaderConfig{Variable: []Variable{{Name: "user", Type: "person_name", Locale: "fr"}}}
+	if err := config.Init(); err == nil {
+		t.Error("expected error of unsupported locale")
+	}
+}
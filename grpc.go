@@ -0,0 +1,427 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/golang/protobuf/proto"
+	"github.com/jamiealquiza/tachymeter"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasttemplate"
+)
+
+// GRPCRequest invokes a unary or server-streaming gRPC method, messages are
+// built from JSON.
+type GRPCRequest struct {
+	// Server address as host:port, default: host of the default endpoint
+	Address string `config:"address"`
+	// Full method name, e.g.: grpc.health.v1.Health/Check
+	Method string `config:"method"`
+	// Load service definitions from .proto files, use server reflection if not set
+	ProtoFiles  []string `config:"proto_files"`
+	ImportPaths []string `config:"import_paths"`
+	// Request message in JSON, supports variables
+	Body     string              `config:"body"`
+	Metadata []map[string]string `config:"metadata"`
+	// Connect with TLS
+	UseTLS bool `config:"use_tls"`
+
+	RuntimeVariables map[string]string `config:"runtime_variables"`
+
+	bodyTemplate      *fasttemplate.Template
+	metadataTemplates map[string]*fasttemplate.Template
+	files             []*desc.FileDescriptor
+	runtimeVariables  *orderedVariables
+
+	lock   sync.Mutex
+	method *desc.MethodDescriptor
+}
+
+var (
+	grpcTLSConfig *tls.Config
+	grpcConns     = map[string]*grpc.ClientConn{}
+	grpcConnsLock sync.Mutex
+)
+
+func (g *GRPCRequest) init() error {
+	if g.Method == "" {
+		return fmt.Errorf("grpc method is not set")
+	}
+
+	g.runtimeVariables = newOrderedVariables(g.RuntimeVariables)
+	var err error
+	if g.bodyTemplate, err = newTemplate(g.Body); err != nil {
+		return err
+	}
+	g.metadataTemplates = map[string]*fasttemplate.Template{}
+	for _, kv := range g.Metadata {
+		for k, v := range kv {
+			if g.metadataTemplates[k], err = newTemplate(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(g.ProtoFiles) > 0 {
+		parser := protoparse.Parser{ImportPaths: g.ImportPaths}
+		if g.files, err = parser.ParseFiles(g.ProtoFiles...); err != nil {
+			return fmt.Errorf("failed to parse proto files %v: %v", g.ProtoFiles, err)
+		}
+	}
+	return nil
+}
+
+// splitMethod splits the full method name into service and method names.
+func (g *GRPCRequest) splitMethod() (service string, method string, err error) {
+	name := strings.TrimPrefix(g.Method, "/")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		i = strings.LastIndex(name, ".")
+	}
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf("invalid grpc method [%s]", g.Method)
+	}
+	return name[:i], name[i+1:], nil
+}
+
+// resolveMethod finds the method from the proto files, or from the server
+// reflection, the descriptor is cached once found.
+func (g *GRPCRequest) resolveMethod(conn *grpc.ClientConn) (*desc.MethodDescriptor, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.method != nil {
+		return g.method, nil
+	}
+
+	serviceName, methodName, err := g.splitMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	var service *desc.ServiceDescriptor
+	if len(g.files) > 0 {
+		for _, file := range g.files {
+			if service = file.FindService(serviceName); service != nil {
+				break
+			}
+		}
+		if service == nil {
+			return nil, fmt.Errorf("service [%s] not found in %v", serviceName, g.ProtoFiles)
+		}
+	} else {
+		client := grpcreflect.NewClientAuto(context.Background(), conn)
+		defer client.Reset()
+		if service, err = client.ResolveService(serviceName); err != nil {
+			return nil, fmt.Errorf("failed to resolve service [%s] by reflection: %v", serviceName, err)
+		}
+	}
+
+	method := service.FindMethodByName(methodName)
+	if method == nil {
+		return nil, fmt.Errorf("method [%s] not found in service [%s]", methodName, serviceName)
+	}
+	if method.IsClientStreaming() {
+		return nil, fmt.Errorf("client streaming method [%s] is not supported", g.Method)
+	}
+	g.method = method
+	return method, nil
+}
+
+func (g *GRPCRequest) resolveAddress(config *LoaderConfig) string {
+	if g.Address != "" {
+		return g.Address
+	}
+	endpoint, err := config.RunnerConfig.parseDefaultEndpoint()
+	if err != nil {
+		return ""
+	}
+	return hostAddr(endpoint)
+}
+
+// getGRPCConn returns the shared connection of the address, gRPC multiplexes
+// all calls over it.
+func getGRPCConn(address string, useTLS bool) (*grpc.ClientConn, error) {
+	key := address
+	if useTLS {
+		key = "tls://" + address
+	}
+
+	grpcConnsLock.Lock()
+	defer grpcConnsLock.Unlock()
+	if conn, ok := grpcConns[key]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(grpcTLSConfig)
+	}
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialConn(addr)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	grpcConns[key] = conn
+	return conn, nil
+}
+
+// grpcHTTPStatus maps gRPC status codes to HTTP status codes, so that they
+// can be counted with the HTTP requests.
+var grpcHTTPStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+func doGRPC(config *LoaderConfig, globalCtx util.MapStr, item *RequestItem, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
+	g := item.GRPC
+
+	runtimeVariables := util.MapStr{}
+	runtimeVariables.Update(globalCtx)
+	g.runtimeVariables.put(runtimeVariables)
+
+	address := g.resolveAddress(config)
+	var method *desc.MethodDescriptor
+	conn, err := getGRPCConn(address, g.UseTLS)
+	if err == nil {
+		method, err = g.resolveMethod(conn)
+	}
+	body := renderTemplate(g.bodyTemplate, g.Body, runtimeVariables)
+	var request *dynamic.Message
+	if err == nil {
+		request = dynamic.NewMessage(method.GetInputType())
+		if len(body) > 0 {
+			err = request.UnmarshalJSON([]byte(body))
+		}
+	}
+	if err != nil {
+		log.Errorf("failed to prepare grpc request [%s] %s, error: %v", address, g.Method, err)
+		loadStats.NumErrs++
+		loadStats.NumAssertInvalid++
+		loadStats.NumRequests++
+		loadStats.StatusCode[0] += 1
+		return true, err
+	}
+
+	md := metadata.MD{}
+	for _, kv := range g.Metadata {
+		for k, v := range kv {
+			md.Append(k, renderTemplate(g.metadataTemplates[k], v, runtimeVariables))
+		}
+	}
+	if exhaustedIn(globalCtx) {
+		return false, nil
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	stub := grpcdynamic.NewStub(conn)
+	var header metadata.MD
+	var responses []proto.Message
+	start := time.Now()
+	if method.IsServerStreaming() {
+		var stream *grpcdynamic.ServerStream
+		stream, err = stub.InvokeRpcServerStream(ctx, method, request)
+		if err == nil {
+			for {
+				response, recvErr := stream.RecvMsg()
+				if recvErr != nil {
+					if recvErr != io.EOF {
+						err = recvErr
+					}
+					break
+				}
+				responses = append(responses, response)
+			}
+			header, _ = stream.Header()
+		}
+	} else {
+		var response proto.Message
+		response, err = stub.InvokeRpc(ctx, method, request, grpc.Header(&header))
+		if err == nil {
+			responses = append(responses, response)
+		}
+	}
+	duration := time.Since(start)
+
+	grpcStatus := status.Convert(err)
+	statusCode, ok := grpcHTTPStatus[grpcStatus.Code()]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+	// Other status are returned by the server and checked by assertions
+	transportErr := grpcStatus.Code() == codes.Unavailable || grpcStatus.Code() == codes.DeadlineExceeded
+
+	respBody := encodeGRPCResponses(responses, method.IsServerStreaming())
+
+	if !config.RunnerConfig.BenchmarkOnly && timer != nil {
+		timer.AddTime(duration)
+	}
+
+	if !config.RunnerConfig.NoStats {
+		if config.RunnerConfig.DurationInUs {
+			stats.Timing("grpc", "duration_in_us", duration.Microseconds())
+		} else {
+			stats.Timing("grpc", "duration", duration.Milliseconds())
+		}
+		stats.Increment("grpc", "total")
+		stats.Increment("grpc", grpcStatus.Code().String())
+
+		if transportErr {
+			loadStats.NumErrs++
+			loadStats.NumAssertInvalid++
+		}
+
+		if !config.RunnerConfig.NoSizeStats {
+			loadStats.TotReqSize += int64(len(body))
+			loadStats.TotRespSize += int64(len(respBody))
+		}
+
+		loadStats.NumRequests++
+		loadStats.TotDuration += duration
+		loadStats.MaxRequestTime = util.MaxDuration(duration, loadStats.MaxRequestTime)
+		loadStats.MinRequestTime = util.MinDuration(duration, loadStats.MinRequestTime)
+		loadStats.StatusCode[statusCode] += 1
+	}
+
+	if config.RunnerConfig.BenchmarkOnly {
+		return true, err
+	}
+
+	if config.RunnerConfig.LogRequests || util.ContainsInAnyInt32Array(statusCode, config.RunnerConfig.LogStatusCodes) {
+		log.Infof("[GRPC] %v/%v, %v - %v", address, g.Method, g.Metadata, util.SubString(body, 0, 512))
+		log.Infof("status: %v, error: %v, response: %v", grpcStatus.Code(), err, util.SubString(string(respBody), 0, 512))
+	}
+
+	if item.Register != nil || item.Assert != nil {
+		responseHeader := map[string]interface{}{}
+		for k, v := range header {
+			if len(v) > 0 {
+				responseHeader[k] = v[0]
+			}
+		}
+		event := util.MapStr{
+			"_ctx": map[string]interface{}{
+				"response": map[string]interface{}{
+					"status":       statusCode,
+					"grpc_status":  int(grpcStatus.Code()),
+					"grpc_message": grpcStatus.Message(),
+					"header":       responseHeader,
+					"body":         string(respBody),
+					"body_length":  len(respBody),
+					"messages":     len(responses),
+				},
+				"elapsed": int64(duration / time.Millisecond),
+			},
+		}
+		putBodyJson(event, "_ctx.response.body_json", respBody)
+		if next, _ := item.registerAndAssert(config, globalCtx, event, len(respBody), loadStats); !next {
+			return false, err
+		}
+	}
+
+	if item.Sleep != nil {
+		time.Sleep(time.Duration(item.Sleep.SleepInMilliSeconds) * time.Millisecond)
+	}
+
+	return true, nil
+}
+
+// encodeGRPCResponses encodes the response messages to JSON, messages of a
+// server stream are encoded as an array, messages failed to encode are
+// skipped.
+func encodeGRPCResponses(responses []proto.Message, streaming bool) []byte {
+	buffer := bytes.Buffer{}
+	if streaming {
+		buffer.WriteString("[")
+	}
+	written := 0
+	for _, response := range responses {
+		message, err := dynamic.AsDynamicMessage(response)
+		if err != nil {
+			continue
+		}
+		data, err := message.MarshalJSON()
+		if err != nil {
+			continue
+		}
+		if written > 0 {
+			buffer.WriteString(",")
+		}
+		buffer.Write(data)
+		written++
+	}
+	if streaming {
+		buffer.WriteString("]")
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,256 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"infini.sh/framework/core/util"
+)
+
+func TestGRPCUnaryByReflection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("search", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	config := &LoaderConfig{
+		Variable: []Variable{{Name: "service", Type: "list", Data: []string{"search"}}},
+		Requests: []RequestItem{
+			{GRPC: &GRPCRequest{
+				Address: listener.Addr().String(),
+				Method:  "grpc.health.v1.Health/Check",
+				Body:    `{"service": "$[[service]]"}`,
+			}},
+			{GRPC: &GRPCRequest{
+				Address: listener.Addr().String(),
+				Method:  "grpc.health.v1.Health/Check",
+				Body:    `{"service": "unknown"}`,
+			}},
+		},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	for i := range config.Requests {
+		if _, err := doGRPC(config, util.MapStr{}, &config.Requests[i], loadStats, nil); err != nil {
+			t.Logf("request #%d: %v", i, err)
+		}
+	}
+	if loadStats.StatusCode[http.StatusOK] != 1 || loadStats.StatusCode[http.StatusNotFound] != 1 {
+		t.Errorf("unexpected status codes: %v", loadStats.StatusCode)
+	}
+	if loadStats.NumErrs != 0 {
+		t.Errorf("unexpected errors: %v", loadStats.NumErrs)
+	}
+}
+
+func TestGRPCSplitMethod(t *testing.T) {
+	for _, method := range []string{"grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Check", "grpc.health.v1.Health.Check"} {
+		service, name, err := (&GRPCRequest{Method: method}).splitMethod()
+		if err != nil || service != "grpc.health.v1.Health" || name != "Check" {
+			t.Errorf("unexpected result of [%s]: %s, %s, %v", method, service, name, err)
+		}
+	}
+}
+
+const greeterProto = `syntax = "proto3";
+package test;
+
+message HelloRequest {
+  string name = 1;
+  int32 count = 2;
+}
+
+message HelloReply {
+  string message = 1;
+}
+
+service Greeter {
+  rpc Hello(HelloRequest) returns (HelloReply);
+  rpc HelloStream(HelloRequest) returns (stream HelloReply);
+}
+`
+
+func TestGRPCProtoFilesAndServerStreaming(t *testing.T) {
+	protoFile := filepath.Join(t.TempDir(), "greeter.proto")
+	if err := os.WriteFile(protoFile, []byte(greeterProto), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := (&protoparse.Parser{}).ParseFiles(protoFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := files[0].FindService("test.Greeter")
+
+	// The server has no reflection, requests are built from the proto file
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		name, _ := grpc.MethodFromServerStream(stream)
+		method := service.FindMethodByName(filepath.Base(name))
+		if method == nil {
+			return status.Errorf(codes.Unimplemented, "unknown method %s", name)
+		}
+		request := dynamic.NewMessage(method.GetInputType())
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+		user := request.GetFieldByName("name").(string)
+		if user == "" {
+			return status.Error(codes.InvalidArgument, "name is required")
+		}
+		count := int(request.GetFieldByName("count").(int32))
+		if !method.IsServerStreaming() {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			reply := dynamic.NewMessage(method.GetOutputType())
+			reply.SetFieldByName("message", fmt.Sprintf("hello %s #%d", user, i))
+			if err := stream.SendMsg(reply); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	config := &LoaderConfig{
+		Requests: []RequestItem{
+			{
+				GRPC: &GRPCRequest{
+					Address:    listener.Addr().String(),
+					Method:     "test.Greeter/HelloStream",
+					ProtoFiles: []string{protoFile},
+					Body:       `{"name": "medcl", "count": 3}`,
+				},
+				Register: []map[string]string{{"body": "_ctx.response.body"}, {"messages": "_ctx.response.messages"}},
+				Assert:   newTestCondition(t, `equals: {_ctx.response.grpc_status: 0}`),
+			},
+			{
+				GRPC: &GRPCRequest{
+					Address:    listener.Addr().String(),
+					Method:     "test.Greeter/Hello",
+					ProtoFiles: []string{protoFile},
+					Body:       `{}`,
+				},
+				Register: []map[string]string{{"code": "_ctx.response.grpc_status"}},
+				Assert:   newTestCondition(t, `equals: {_ctx.response.grpc_status: 3}`),
+			},
+			{
+				GRPC: &GRPCRequest{
+					Address:    listener.Addr().String(),
+					Method:     "test.Greeter/Hello",
+					ProtoFiles: []string{protoFile},
+					Body:       `{}`,
+				},
+				Assert: newTestCondition(t, `equals: {_ctx.response.grpc_status: 0}`),
+			},
+		},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	globalCtx := util.MapStr{}
+	loadStats := &LoadStats{MinRequestTime: time.Minute, StatusCode: map[int]int{}}
+	for i, expected := range []bool{true, true, false} {
+		next, _ := doGRPC(config, globalCtx, &config.Requests[i], loadStats, nil)
+		if next != expected {
+			t.Errorf("request #%d: expected next %v, got %v", i, expected, next)
+		}
+	}
+
+	if body, _ := globalCtx.GetValue("body"); !strings.HasPrefix(fmt.Sprint(body), "[") || !strings.Contains(fmt.Sprint(body), "hello medcl #2") {
+		t.Errorf("unexpected streamed messages: %v", body)
+	}
+	if messages, _ := globalCtx.GetValue("messages"); messages != 3 {
+		t.Errorf("unexpected number of streamed messages: %v", messages)
+	}
+	if code, _ := globalCtx.GetValue("code"); code != int(codes.InvalidArgument) {
+		t.Errorf("unexpected registered grpc status: %v", code)
+	}
+	if loadStats.NumAssertInvalid != 1 || loadStats.NumErrs != 0 {
+		t.Errorf("unexpected assert invalid: %v, errors: %v", loadStats.NumAssertInvalid, loadStats.NumErrs)
+	}
+	if loadStats.StatusCode[http.StatusOK] != 1 || loadStats.StatusCode[http.StatusBadRequest] != 2 {
+		t.Errorf("unexpected status codes: %v", loadStats.StatusCode)
+	}
+}
+
+func TestEncodeGRPCResponses(t *testing.T) {
+	md, err := desc.LoadMessageDescriptorForMessage(&anypb.Any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An Any of an unknown type can't be encoded to JSON
+	unknown := dynamic.NewMessage(md)
+	unknown.SetFieldByName("type_url", "type.googleapis.com/unknown.Type")
+
+	responses := []proto.Message{
+		unknown,
+		&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING},
+		unknown,
+		&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	body := encodeGRPCResponses(responses, true)
+	var messages []map[string]interface{}
+	if err = json.Unmarshal(body, &messages); err != nil {
+		t.Fatalf("invalid JSON of responses: %s, %v", body, err)
+	}
+	if !strings.Contains(string(body), `"SERVING"`) || !strings.Contains(string(body), `"NOT_SERVING"`) {
+		t.Errorf("unexpected responses: %s", body)
+	}
+}
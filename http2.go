@@ -0,0 +1,292 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// http2Client sends fasthttp requests over a fixed number of HTTP/2
+// connections per host, so that the multiplexing can be measured.
+type http2Client struct {
+	// Dial opens the underlying TCP connection
+	Dial func(addr string) (net.Conn, error)
+	// TLSConfig is used for h2, leave it empty for h2c
+	TLSConfig *tls.Config
+	// Connections to open per host, default: 1
+	Connections int
+	// MaxConcurrentStreams per connection, default: 0 (limited by the server)
+	MaxConcurrentStreams int
+	// Name is sent as User-Agent if the request has no one
+	Name string
+	// Deadline of a request without timeout is the sum of ReadTimeout and
+	// WriteTimeout, as reading and writing of streams are not separated
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	transport *http2.Transport
+	lock      sync.Mutex
+	hosts     map[string]*http2HostPool
+}
+
+// http2Stats counts the connections and streams of all HTTP/2 clients,
+// including the clients of requests and VUs.
+var http2Stats struct {
+	connections int64
+	streams     int64
+}
+
+// resetHTTP2Stats clears http2Stats before a run.
+func resetHTTP2Stats() {
+	atomic.StoreInt64(&http2Stats.connections, 0)
+	atomic.StoreInt64(&http2Stats.streams, 0)
+}
+
+type http2HostPool struct {
+	lock  sync.Mutex
+	next  uint32
+	conns []*http2Conn
+}
+
+type http2Conn struct {
+	cc      *http2.ClientConn
+	streams chan struct{}
+}
+
+func (conn *http2Conn) acquire(ctx context.Context) error {
+	if conn.streams == nil {
+		return nil
+	}
+	select {
+	case conn.streams <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (conn *http2Conn) release() {
+	if conn.streams != nil {
+		<-conn.streams
+	}
+}
+
+func (c *http2Client) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	return c.DoTimeout(req, resp, 0)
+}
+
+func (c *http2Client) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout <= 0 {
+		timeout = c.ReadTimeout + c.WriteTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	httpReq, err := toHTTPRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if httpReq.Header.Get("User-Agent") == "" && c.Name != "" {
+		httpReq.Header.Set("User-Agent", c.Name)
+	}
+
+	conn, err := c.getConn(hostAddr(req.URI()))
+	if err != nil {
+		return err
+	}
+	if err = conn.acquire(ctx); err != nil {
+		return err
+	}
+	defer conn.release()
+
+	atomic.AddInt64(&http2Stats.streams, 1)
+	httpResp, err := conn.cc.RoundTrip(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	return copyHTTPResponse(httpResp, resp)
+}
+
+// getConn picks the connections of the host in round-robin, broken
+// connections are replaced by new ones.
+func (c *http2Client) getConn(addr string) (*http2Conn, error) {
+	c.lock.Lock()
+	if c.hosts == nil {
+		c.hosts = map[string]*http2HostPool{}
+		c.transport = &http2.Transport{
+			AllowHTTP:                  c.TLSConfig == nil,
+			StrictMaxConcurrentStreams: true,
+			// Send requests and read responses as is like fasthttp
+			DisableCompression: true,
+		}
+	}
+	pool, ok := c.hosts[addr]
+	if !ok {
+		connections := c.Connections
+		if connections <= 0 {
+			connections = 1
+		}
+		pool = &http2HostPool{conns: make([]*http2Conn, connections)}
+		c.hosts[addr] = pool
+	}
+	c.lock.Unlock()
+
+	i := int(atomic.AddUint32(&pool.next, 1)) % len(pool.conns)
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	conn := pool.conns[i]
+	if conn != nil && conn.cc.CanTakeNewRequest() {
+		return conn, nil
+	}
+	if conn != nil {
+		conn.cc.Close()
+	}
+
+	netConn, err := c.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := c.transport.NewClientConn(netConn)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	atomic.AddInt64(&http2Stats.connections, 1)
+	conn = &http2Conn{cc: cc}
+	if c.MaxConcurrentStreams > 0 {
+		conn.streams = make(chan struct{}, c.MaxConcurrentStreams)
+	}
+	pool.conns[i] = conn
+	return conn, nil
+}
+
+func (c *http2Client) dial(addr string) (net.Conn, error) {
+	conn, err := c.Dial(addr)
+	if err != nil || c.TLSConfig == nil {
+		return conn, err
+	}
+
+	tlsConfig := c.TLSConfig.Clone()
+	tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+	if tlsConfig.ServerName == "" {
+		host, _, splitErr := net.SplitHostPort(addr)
+		if splitErr != nil {
+			host = addr
+		}
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+		tlsConn.Close()
+		return nil, fmt.Errorf("server [%s] does not support h2, negotiated protocol: [%s]", addr, proto)
+	}
+	return tlsConn, nil
+}
+
+// hostAddr returns host:port of the uri, with the default port of the scheme
+// if it's not specified.
+func hostAddr(uri *fasthttp.URI) string {
+	host := string(uri.Host())
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	if string(uri.Scheme()) == "https" {
+		return net.JoinHostPort(host, "443")
+	}
+	return net.JoinHostPort(host, "80")
+}
+
+// Connection-specific headers are not allowed in HTTP/2.
+var http2SkippedHeaders = map[string]bool{
+	"Host":              true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Content-Length":    true,
+}
+
+// toHTTPRequest converts a fasthttp request to a net/http request.
+func toHTTPRequest(ctx context.Context, req *fasthttp.Request) (*http.Request, error) {
+	body := req.Body()
+	httpReq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()), req.URI().String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.ContentLength = int64(len(body))
+	httpReq.Host = string(req.Host())
+	req.Header.VisitAll(func(k, v []byte) {
+		key := http.CanonicalHeaderKey(string(k))
+		if http2SkippedHeaders[key] {
+			return
+		}
+		httpReq.Header.Add(string(k), string(v))
+	})
+	return httpReq, nil
+}
+
+// copyHTTPResponse copies status, headers and body of a net/http response to
+// the fasthttp response.
+func copyHTTPResponse(httpResp *http.Response, resp *fasthttp.Response) error {
+	resp.SetStatusCode(httpResp.StatusCode)
+	for k, values := range httpResp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range values {
+			resp.Header.Add(k, v)
+		}
+	}
+	_, err := io.Copy(resp.BodyWriter(), httpResp.Body)
+	resp.Header.SetContentLength(len(resp.Body()))
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestHTTP2ClientH2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Proto", r.Proto)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	client := &http2Client{Dial: dialConn, Connections: 2, MaxConcurrentStreams: 4}
+	streams, connections := atomic.LoadInt64(&http2Stats.streams), atomic.LoadInt64(&http2Stats.connections)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+
+			req.SetRequestURI(server.URL + "/echo")
+			req.Header.SetMethod(fasthttp.MethodPost)
+			req.SetBodyString("hello")
+			if err := client.Do(req, resp); err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.StatusCode() != http.StatusCreated {
+				t.Errorf("unexpected status: %v", resp.StatusCode())
+			}
+			if proto := string(resp.Header.Peek("X-Proto")); proto != "HTTP/2.0" {
+				t.Errorf("unexpected protocol: %v", proto)
+			}
+			if body := string(resp.Body()); body != "hello" {
+				t.Errorf("unexpected body: %v", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if streams := atomic.LoadInt64(&http2Stats.streams) - streams; streams != 10 {
+		t.Errorf("expected 10 streams, got %v", streams)
+	}
+	if connections := atomic.LoadInt64(&http2Stats.connections) - connections; connections != 2 {
+		t.Errorf("expected 2 HTTP/2 connections, got %v", connections)
+	}
+	if len(client.hosts) != 1 {
+		t.Fatalf("expected 1 host pool, got %v", len(client.hosts))
+	}
+	for _, pool := range client.hosts {
+		if len(pool.conns) != 2 {
+			t.Errorf("expected 2 connections, got %v", len(pool.conns))
+		}
+	}
+}
+
+func TestHTTP2ClientTimeoutAndEncoding(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer server.Close()
+
+	client := &http2Client{Dial: dialConn, ReadTimeout: 100 * time.Millisecond, WriteTimeout: 100 * time.Millisecond}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(server.URL + "/")
+	if err := client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if body := string(resp.Body()); body != "" {
+		t.Errorf("unexpected Accept-Encoding: %v", body)
+	}
+
+	req.SetRequestURI(server.URL + "/slow")
+	start := time.Now()
+	if err := client.Do(req, resp); err == nil {
+		t.Error("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to time out after 200ms, took %v", elapsed)
+	}
+}
@@ -29,11 +29,11 @@ package main
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
-	"net"
 	"os"
 	"strconv"
 	"sync"
@@ -49,6 +49,7 @@ import (
 	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/framework/lib/fasttemplate"
 )
 
 type LoadGenerator struct {
@@ -56,6 +57,8 @@ type LoadGenerator struct {
 	goroutines      int
 	statsAggregator chan *LoadStats
 	interrupted     int32
+	// Number of started virtual users
+	vus int32
 }
 
 type LoadStats struct {
@@ -69,10 +72,17 @@ type LoadStats struct {
 	NumAssertInvalid int
 	NumAssertSkipped int
 	StatusCode       map[int]int
+	// WebSocket stats by scenario name
+	WebSocket map[string]*WebSocketStats
+	// Streaming response stats by stream name
+	Stream map[string]*StreamStats
+	// Redirects followed, the time is included in TotDuration
+	NumRedirects        int
+	TotRedirectDuration time.Duration
 }
 
 var (
-	httpClient fasthttp.Client
+	httpClient requestDoer
 	resultPool = &sync.Pool{
 		New: func() interface{} {
 			return &RequestResult{}
@@ -80,7 +90,7 @@ var (
 	}
 )
 
-func NewLoadGenerator(duration int, goroutines int, statsAggregator chan *LoadStats, disableHeaderNamesNormalizing bool) (rt *LoadGenerator) {
+func NewLoadGenerator(duration int, goroutines int, statsAggregator chan *LoadStats, config *LoaderConfig) (rt *LoadGenerator) {
 	if readTimeout <= 0 {
 		readTimeout = timeout
 	}
@@ -91,33 +101,115 @@ func NewLoadGenerator(duration int, goroutines int, statsAggregator chan *LoadSt
 		dialTimeout = timeout
 	}
 
-	httpClient = fasthttp.Client{
-		MaxConnsPerHost: goroutines,
-		//MaxConns: goroutines,
-		NoDefaultUserAgentHeader:      false,
-		DisableHeaderNamesNormalizing: disableHeaderNamesNormalizing,
-		Name:                          global.Env().GetAppLowercaseName() + "/" + global.Env().GetVersion() + "/" + global.Env().GetBuildNumber(),
-		TLSConfig:                     &tls.Config{InsecureSkipVerify: true},
+	runnerConfig := &config.RunnerConfig
+	tlsConfig := runnerConfig.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig, _ = newTLSConfig(nil)
 	}
 
-	if readTimeout > 0 {
-		httpClient.ReadTimeout = time.Second * time.Duration(readTimeout)
-	}
-	if writeTimeout > 0 {
-		httpClient.WriteTimeout = time.Second * time.Duration(writeTimeout)
-	}
-	if dialTimeout > 0 {
-		httpClient.Dial = func(addr string) (net.Conn, error) {
-			return fasthttp.DialTimeout(addr, time.Duration(dialTimeout)*time.Second)
+	httpClient = newHTTPClient(runnerConfig, goroutines, tlsConfig, dialConn)
+	streamClient = newStreamClient(runnerConfig, tlsConfig)
+	for _, item := range config.Requests {
+		if item.Request != nil && item.Request.tlsConfig != nil {
+			item.Request.client = newHTTPClient(runnerConfig, goroutines, item.Request.tlsConfig, dialConn)
+			if item.Request.Stream != nil {
+				item.Request.streamClient = newStreamClient(runnerConfig, item.Request.tlsConfig)
+			}
 		}
 	}
 
-	rt = &LoadGenerator{duration, goroutines, statsAggregator, 0}
+	connProxy = runnerConfig.proxy
+	localAddrs = runnerConfig.localAddrs
+	connResolver = runnerConfig.resolver
+	webSocketDialer = newWebSocketDialer(tlsConfig)
+	oauth2Client = newOAuth2Client(tlsConfig)
+	grpcTLSConfig = tlsConfig
+
+	rt = &LoadGenerator{duration, goroutines, statsAggregator, 0, 0}
 	return
 }
 
+// newHTTPClient creates the client of the protocol with the TLS config and
+// the dial func.
+func newHTTPClient(runnerConfig *RunnerConfig, goroutines int, tlsConfig *tls.Config, dial fasthttp.DialFunc) requestDoer {
+	clientName := global.Env().GetAppLowercaseName() + "/" + global.Env().GetVersion() + "/" + global.Env().GetBuildNumber()
+
+	var doer requestDoer
+	switch runnerConfig.Protocol {
+	case protocolH2, protocolH2C:
+		client := &http2Client{
+			Dial:                 dial,
+			Connections:          runnerConfig.H2Connections,
+			MaxConcurrentStreams: runnerConfig.H2MaxConcurrentStreams,
+			Name:                 clientName,
+			ReadTimeout:          time.Second * time.Duration(readTimeout),
+			WriteTimeout:         time.Second * time.Duration(writeTimeout),
+		}
+		if runnerConfig.Protocol == protocolH2 {
+			client.TLSConfig = tlsConfig
+		}
+		doer = client
+	default:
+		maxConns := goroutines
+		if runnerConfig.MaxConnsPerHost > 0 {
+			maxConns = runnerConfig.MaxConnsPerHost
+		}
+		client := &fasthttp.Client{
+			MaxConnsPerHost: maxConns,
+			//MaxConns: goroutines,
+			NoDefaultUserAgentHeader:      false,
+			DisableHeaderNamesNormalizing: runnerConfig.DisableHeaderNamesNormalizing,
+			Name:                          clientName,
+			TLSConfig:                     tlsConfig,
+			Dial:                          dial,
+		}
+
+		if readTimeout > 0 {
+			client.ReadTimeout = time.Second * time.Duration(readTimeout)
+		}
+		if writeTimeout > 0 {
+			client.WriteTimeout = time.Second * time.Duration(writeTimeout)
+		}
+		if maxConns < goroutines {
+			// Wait for a free connection instead of failing immediately
+			client.MaxConnWaitTimeout = time.Minute
+			if timeout > 0 {
+				client.MaxConnWaitTimeout = time.Second * time.Duration(timeout)
+			}
+		}
+		client.MaxIdleConnDuration = time.Millisecond * time.Duration(runnerConfig.MaxConnIdleInMilliSeconds)
+		client.MaxConnDuration = time.Millisecond * time.Duration(runnerConfig.MaxConnLifetimeInMilliSeconds)
+		doer = client
+	}
+
+	if runnerConfig.endpoints != nil {
+		doer = &balancedClient{requestDoer: doer, pool: runnerConfig.endpoints}
+	}
+	return doer
+}
+
 var defaultHTTPPool = fasthttp.NewRequestResponsePool("default_http")
 
+// doItem executes the request item with the client of its kind.
+func doItem(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Request, resp *fasthttp.Response, item *RequestItem, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
+	if item.WebSocket != nil {
+		return doWebSocket(config, globalCtx, item, loadStats, timer)
+	}
+	if item.GRPC != nil {
+		return doGRPC(config, globalCtx, item, loadStats, timer)
+	}
+	if item.TCP != nil {
+		return doSocket(config, globalCtx, item, item.TCP, loadStats, timer)
+	}
+	if item.UDP != nil {
+		return doSocket(config, globalCtx, item, item.UDP, loadStats, timer)
+	}
+	if item.Request != nil && item.Request.Stream != nil {
+		return doStream(config, globalCtx, req, resp, item, loadStats, timer)
+	}
+	return doRequest(config, globalCtx, req, resp, item, loadStats, timer)
+}
+
 func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Request, resp *fasthttp.Response, item *RequestItem, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
 
 	if item.Request != nil {
@@ -126,9 +218,22 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 			item.Request.ExecuteRepeatTimes = 1
 		}
 
+		client := httpClient
+		if item.Request.client != nil {
+			client = item.Request.client
+		}
+		if clients := vuClientsOf(globalCtx); clients != nil {
+			client = clients.get(item.Request.tlsConfig)
+		}
+		redirect := item.redirectConfig(config)
+		auth := item.Request.authConfig(config)
+
 		for i := 0; i < item.Request.ExecuteRepeatTimes; i++ {
 			resp.Reset()
 			resp.ResetBody()
+			if i > 0 {
+				resetBodyStream(req)
+			}
 			start := time.Now()
 
 			if global.Env().IsDebug {
@@ -136,9 +241,27 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 			}
 
 			if timeout > 0 {
-				err = httpClient.DoTimeout(req, resp, time.Duration(timeout)*time.Second)
+				err = client.DoTimeout(req, resp, time.Duration(timeout)*time.Second)
 			} else {
-				err = httpClient.Do(req, resp)
+				err = client.Do(req, resp)
+			}
+
+			if err == nil && resp.StatusCode() == fasthttp.StatusUnauthorized && auth != nil && auth.refresh(req) {
+				// Retry once with the refreshed token
+				resetBodyStream(req)
+				resp.Reset()
+				if timeout > 0 {
+					err = client.DoTimeout(req, resp, time.Duration(timeout)*time.Second)
+				} else {
+					err = client.Do(req, resp)
+				}
+			}
+
+			var hops []redirectHop
+			if err == nil && redirect != nil && redirect.Enabled && isRedirect(resp.StatusCode()) {
+				hops, err = followRedirects(client, redirect, auth, globalCtx, req, resp, time.Since(start))
+			} else if jar := cookieJarOf(globalCtx); err == nil && jar != nil {
+				jar.update(globalCtx, req, resp)
 			}
 
 			if global.Env().IsDebug {
@@ -177,6 +300,12 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 				loadStats.MaxRequestTime = util.MaxDuration(duration, loadStats.MaxRequestTime)
 				loadStats.MinRequestTime = util.MinDuration(duration, loadStats.MinRequestTime)
 				loadStats.StatusCode[statsCode] += 1
+
+				for _, hop := range hops {
+					stats.Increment("request", "redirects")
+					loadStats.NumRedirects++
+					loadStats.TotRedirectDuration += hop.duration
+				}
 			}
 
 			if config.RunnerConfig.BenchmarkOnly {
@@ -187,6 +316,13 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 				//only use last request and response
 				reqBody := req.GetRawBody()
 				respBody := resp.GetRawBody()
+				if !config.RunnerConfig.DisableResponseDecompression {
+					if decoded, decodeErr := decodeBody(resp); decodeErr == nil {
+						respBody = decoded
+					} else {
+						log.Warnf("%v, response is not decoded", decodeErr)
+					}
+				}
 				if global.Env().IsDebug {
 					log.Debugf("final response code: %v, body: %s", resp.StatusCode(), string(respBody))
 				}
@@ -201,47 +337,19 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 				}
 
 				event := buildCtx(resp, respBody, duration)
-				if item.Register != nil {
-					log.Debugf("registering %+v, event: %+v", item.Register, event)
-					for _, item := range item.Register {
-						for dest, src := range item {
-							val, valErr := event.GetValue(src)
-							if valErr != nil {
-								log.Errorf("failed to get value with key: %s", src)
-							}
-							log.Debugf("put globalCtx %+v, %+v", dest, val)
-							globalCtx.Put(dest, val)
-						}
+				if len(hops) > 0 {
+					redirects := make([]map[string]interface{}, 0, len(hops))
+					for _, hop := range hops {
+						redirects = append(redirects, hop.toMap())
 					}
+					event.Put("_ctx.response.redirects", redirects)
 				}
-
-				if item.Assert != nil {
-					// Dump globalCtx into assert event
-					event.Update(globalCtx)
-					if len(respBody) < 4096 {
-						log.Debugf("assert _ctx: %+v", event)
-					}
-					condition, buildErr := conditions.NewCondition(item.Assert)
-					if buildErr != nil {
-						if config.RunnerConfig.SkipInvalidAssert {
-							loadStats.NumAssertSkipped++
-							continue
-						}
-						log.Errorf("failed to build conditions while assert existed, error: %+v", buildErr)
-						loadStats.NumAssertInvalid++
-						return
-					}
-					if !condition.Check(event) {
-						loadStats.NumAssertInvalid++
-						if item.Request != nil {
-							log.Errorf("%s %s, assertion failed, skipping subsequent requests", item.Request.Method, item.Request.Url)
-						}
-
-						if !config.RunnerConfig.ContinueOnAssertInvalid {
-							log.Info("assertion failed, skipping subsequent requests,", util.MustToJSON(item.Assert), ", event:", util.MustToJSON(event))
-							return false, err
-						}
-					}
+				next, skipped := item.registerAndAssert(config, globalCtx, event, len(respBody), loadStats)
+				if skipped {
+					continue
+				}
+				if !next {
+					return false, err
 				}
 			}
 
@@ -254,13 +362,66 @@ func doRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Reques
 	return true, nil
 }
 
+// registerAndAssert populates globalCtx with the registered values of the
+// event and checks the assertion, skipped is true if the assertion is invalid
+// and skipped, next is false if subsequent requests should be skipped.
+func (item *RequestItem) registerAndAssert(config *LoaderConfig, globalCtx util.MapStr, event util.MapStr, bodyLength int, loadStats *LoadStats) (next bool, skipped bool) {
+	if item.Register != nil {
+		log.Debugf("registering %+v, event: %+v", item.Register, event)
+		for _, item := range item.Register {
+			for dest, src := range item {
+				val, valErr := event.GetValue(src)
+				if valErr != nil {
+					log.Errorf("failed to get value with key: %s", src)
+				}
+				log.Debugf("put globalCtx %+v, %+v", dest, val)
+				globalCtx.Put(dest, val)
+			}
+		}
+	}
+
+	if item.Assert != nil {
+		// Dump globalCtx into assert event
+		event.Update(globalCtx)
+		if bodyLength < 4096 {
+			log.Debugf("assert _ctx: %+v", event)
+		}
+		condition, buildErr := conditions.NewCondition(item.Assert)
+		if buildErr != nil {
+			if config.RunnerConfig.SkipInvalidAssert {
+				loadStats.NumAssertSkipped++
+				return true, true
+			}
+			log.Errorf("failed to build conditions while assert existed, error: %+v", buildErr)
+			loadStats.NumAssertInvalid++
+			return false, false
+		}
+		if !condition.Check(event) {
+			loadStats.NumAssertInvalid++
+			log.Errorf("%s, assertion failed, skipping subsequent requests", item.String())
+
+			if !config.RunnerConfig.ContinueOnAssertInvalid {
+				log.Info("assertion failed, skipping subsequent requests,", util.MustToJSON(item.Assert), ", event:", util.MustToJSON(event))
+				return false, false
+			}
+		}
+	}
+	return true, false
+}
+
 func buildCtx(resp *fasthttp.Response, respBody []byte, duration time.Duration) util.MapStr {
 	var statusCode int
 	header := map[string]interface{}{}
+	cookies := map[string]interface{}{}
+	rawBodyLength := len(respBody)
 	if resp != nil {
+		rawBodyLength = len(resp.GetRawBody())
 		resp.Header.VisitAll(func(k, v []byte) {
 			header[string(k)] = string(v)
 		})
+		for _, cookie := range responseCookies(resp) {
+			cookies[cookie.Name] = cookie.Value
+		}
 		statusCode = resp.StatusCode()
 	}
 	event := util.MapStr{
@@ -268,26 +429,34 @@ func buildCtx(resp *fasthttp.Response, respBody []byte, duration time.Duration)
 			"response": map[string]interface{}{
 				"status":      statusCode,
 				"header":      header,
+				"cookies":     cookies,
 				"body":        string(respBody),
 				"body_length": len(respBody),
+				// Length of the body before decoding
+				"raw_body_length": rawBodyLength,
 			},
 			"elapsed": int64(duration / time.Millisecond),
 		},
 	}
 
+	putBodyJson(event, "_ctx.response.body_json", respBody)
+	return event
+}
+
+// putBodyJson puts the body to the event if it's a valid JSON object or array.
+func putBodyJson(event util.MapStr, key string, body []byte) {
 	bodyJsonArray := []map[string]interface{}{}
-	jsonErr := json.Unmarshal(respBody, &bodyJsonArray)
+	jsonErr := json.Unmarshal(body, &bodyJsonArray)
 	if jsonErr == nil && len(bodyJsonArray) > 0 {
-		event.Put("_ctx.response.body_json", bodyJsonArray)
-		return event
+		event.Put(key, bodyJsonArray)
+		return
 	}
 
 	bodyJson := map[string]interface{}{}
-	jsonErr = json.Unmarshal(respBody, &bodyJson)
+	jsonErr = json.Unmarshal(body, &bodyJson)
 	if jsonErr == nil {
-		event.Put("_ctx.response.body_json", bodyJson)
+		event.Put(key, bodyJson)
 	}
-	return event
 }
 
 func (cfg *LoadGenerator) Run(config *LoaderConfig, countLimit int, timer *tachymeter.Tachymeter) {
@@ -295,9 +464,19 @@ func (cfg *LoadGenerator) Run(config *LoaderConfig, countLimit int, timer *tachy
 	start := time.Now()
 
 	limiter := rate.GetRateLimiter("loadgen", "requests", int(rateLimit), 1, time.Second*1)
+	vu := int(atomic.AddInt32(&cfg.vus, 1)) - 1
+	vuRequests := 0
+	var jar *cookieJar
 
 	// TODO: support concurrent access
-	globalCtx := util.MapStr{}
+	globalCtx := util.MapStr{vuKey: vu}
+	if localAddrs != nil && localAddrs.perVU {
+		tlsConfig := config.RunnerConfig.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig, _ = newTLSConfig(nil)
+		}
+		globalCtx[vuClientsKey] = newVUClients(&config.RunnerConfig, tlsConfig, vu)
+	}
 	req := defaultHTTPPool.AcquireRequest()
 	defer defaultHTTPPool.ReleaseRequest(req)
 	resp := defaultHTTPPool.AcquireResponse()
@@ -305,6 +484,8 @@ func (cfg *LoadGenerator) Run(config *LoaderConfig, countLimit int, timer *tachy
 
 	totalRequests := 0
 	totalRounds := 0
+	sources := csvSources()
+	cursors := listCursors()
 
 	for time.Since(start).Seconds() <= float64(cfg.duration) && atomic.LoadInt32(&cfg.interrupted) == 0 {
 		if config.RunnerConfig.TotalRounds > 0 && totalRounds >= config.RunnerConfig.TotalRounds {
@@ -312,6 +493,16 @@ func (cfg *LoadGenerator) Run(config *LoaderConfig, countLimit int, timer *tachy
 		}
 		totalRounds += 1
 
+		if config.RunnerConfig.CookieJar && (jar == nil || config.RunnerConfig.ResetCookiesEachRound) {
+			jar = newCookieJar()
+			globalCtx[cookieJarKey] = jar
+			delete(globalCtx, "cookie")
+		}
+
+		if !cfg.bindRows(sources, globalCtx, totalRounds == 1) {
+			goto END
+		}
+
 		for i, item := range config.Requests {
 
 			if !config.RunnerConfig.BenchmarkOnly {
@@ -329,9 +520,25 @@ func (cfg *LoadGenerator) Run(config *LoaderConfig, countLimit int, timer *tachy
 				}
 			}
 
-			item.prepareRequest(config, globalCtx, req)
+			if item.Request != nil {
+				if err := item.prepareRequest(config, globalCtx, req, vu); err != nil {
+					log.Warnf("failed to prepare request [%v]: %v", item.String(), err)
+					loadStats.NumErrs++
+					continue
+				}
+				vuRequests++
+				if config.RunnerConfig.MaxConnRequests > 0 && vuRequests%config.RunnerConfig.MaxConnRequests == 0 {
+					req.SetConnectionClose()
+				}
+				if jar != nil {
+					jar.apply(req)
+				}
+			}
+			if cursorsExhausted(cursors, vu) {
+				goto END
+			}
 
-			next, err := doRequest(config, globalCtx, req, resp, &item, loadStats, timer)
+			next, err := doItem(config, globalCtx, req, resp, &item, loadStats, timer)
 			if global.Env().IsDebug {
 				log.Debugf("#%v,contine: %v, err:%v", i, next, err)
 			}
@@ -346,10 +553,18 @@ END:
 	cfg.statsAggregator <- loadStats
 }
 
-func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Request) {
+// prepareRequest renders the request into req, an error is returned if the
+// body can't be built, such as a missing file to upload or an unreadable body
+// file.
+func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Request, vu int) error {
 	//cleanup
 	req.Reset()
 	req.ResetBody()
+	bodyStreams.Delete(req)
+
+	if config.RunnerConfig.DisableKeepAlive {
+		req.SetConnectionClose()
+	}
 
 	if v.Request.BasicAuth != nil && v.Request.BasicAuth.Username != "" {
 		req.SetBasicAuth(v.Request.BasicAuth.Username, v.Request.BasicAuth.Password.Get())
@@ -363,46 +578,27 @@ func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr
 	if v.Request.SimpleMode {
 		req.Header.SetMethod(v.Request.Method)
 		req.SetRequestURI(v.Request.Url)
-		return
+		return nil
 	}
 
 	bodyBuffer := req.BodyBuffer()
-	var bodyWriter io.Writer = bodyBuffer
 	if v.Request.DisableHeaderNamesNormalizing {
 		req.Header.DisableNormalizing()
 	}
 
-	if compress {
-		var err error
-		gzipWriter, err := gzip.NewWriterLevel(bodyBuffer, fasthttp.CompressBestCompression)
-		if err != nil {
-			panic("failed to create gzip writer")
-		}
-		defer gzipWriter.Close()
-		bodyWriter = gzipWriter
-	}
-
 	//init runtime variables
 	// TODO: optimize overall variable populate flow
 	runtimeVariables := util.MapStr{}
 	runtimeVariables.Update(globalCtx)
 
 	if v.Request.HasVariable() {
-		if len(v.Request.RuntimeVariables) > 0 {
-			for k, v := range v.Request.RuntimeVariables {
-				runtimeVariables.Put(k, GetVariable(runtimeVariables, v))
-			}
-		}
-
+		v.Request.runtimeVariables.put(runtimeVariables)
 	}
 
 	//prepare url
 	url := v.Request.Url
 	if v.Request.urlHasTemplate {
-		url = v.Request.urlTemplate.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-			variable := GetVariable(runtimeVariables, tag)
-			return w.Write(util.UnsafeStringToBytes(variable))
-		})
+		url = v.Request.urlTemplate.ExecuteFuncString(v.Request.urlEscapes.tagFunc(runtimeVariables))
 	}
 
 	//set default endpoint
@@ -412,7 +608,7 @@ func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr
 		panic(err)
 	}
 	if parsedUrl.Host() == nil || len(parsedUrl.Host()) == 0 {
-		path, err := config.RunnerConfig.parseDefaultEndpoint()
+		path, err := config.RunnerConfig.pickEndpoint(vu)
 		//log.Infof("default endpoint: %v, %v",path,err)
 		if err == nil {
 			parsedUrl.SetSchemeBytes(path.Scheme())
@@ -434,10 +630,7 @@ func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr
 		for _, headers := range v.Request.Headers {
 			for headerK, headerV := range headers {
 				if tmpl, ok := v.Request.headerTemplates[headerK]; ok {
-					headerV = tmpl.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-						variable := GetVariable(runtimeVariables, tag)
-						return w.Write(util.UnsafeStringToBytes(variable))
-					})
+					headerV = tmpl.ExecuteFuncString(v.Request.headerEscapes[headerK].tagFunc(runtimeVariables))
 				}
 				req.Header.Set(headerK, headerV)
 			}
@@ -450,32 +643,95 @@ func (v *RequestItem) prepareRequest(config *LoaderConfig, globalCtx util.MapStr
 	//req.Header.Set("User-Agent", UserAgent)
 
 	//prepare request body
-	for i := 0; i < v.Request.RepeatBodyNTimes; i++ {
-		body := v.Request.Body
-		if len(body) > 0 {
-			if v.Request.bodyHasTemplate {
-				if len(v.Request.RuntimeBodyLineVariables) > 0 {
-					for k, v := range v.Request.RuntimeBodyLineVariables {
-						runtimeVariables[k] = GetVariable(runtimeVariables, v)
-					}
-				}
+	var bodyTemplate *fasttemplate.Template
+	if v.Request.bodyHasTemplate {
+		bodyTemplate = v.Request.bodyTemplate
+	}
+	compression := v.Request.compression(config)
+	compressed := compression != nil && compression.Codec != codecNone
+	var stream bodyStream
+	var section *fileSection
+	if len(v.Request.Multipart) > 0 {
+		body, err := buildMultipartBody(v.Request.Multipart, runtimeVariables)
+		if err != nil {
+			return err
+		}
+		stream = body
+	} else if v.Request.bodyFile != nil {
+		section = v.Request.bodyFile.chunk(vu)
+		if !v.Request.BodyFileTemplate && !compressed {
+			stream = section
+		}
+	}
 
-				v.Request.bodyTemplate.ExecuteFuncStringExtend(bodyWriter, func(w io.Writer, tag string) (int, error) {
-					variable := GetVariable(runtimeVariables, tag)
-					return w.Write([]byte(variable))
-				})
-			} else {
-				bodyWriter.Write(util.UnsafeStringToBytes(body))
+	writeRequestBody := func(w io.Writer) error {
+		if section == nil {
+			writeBody(w, v.Request.Body, bodyTemplate, v.Request.bodyEscapes, v.Request.RepeatBodyNTimes, v.Request.bodyLineVariables, runtimeVariables)
+			return nil
+		}
+		if v.Request.BodyFileTemplate {
+			return section.writeLines(w, v.Request.bodyEscape, v.Request.bodyLineVariables, runtimeVariables)
+		}
+		_, err := io.Copy(w, section.reader())
+		return err
+	}
+
+	payloadSize := 0
+	if stream != nil {
+		stream.setBody(req)
+		bodyStreams.Store(req, stream)
+		payloadSize = req.Header.ContentLength()
+	} else if !compressed {
+		if err := writeRequestBody(bodyBuffer); err != nil {
+			return fmt.Errorf("failed to write body: %v", err)
+		}
+		payloadSize = bodyBuffer.Len()
+	} else {
+		buffer := bodyBufferPool.Get().(*bytes.Buffer)
+		defer bodyBufferPool.Put(buffer)
+		buffer.Reset()
+		if err := writeRequestBody(buffer); err != nil {
+			return fmt.Errorf("failed to write body: %v", err)
+		}
+		if buffer.Len() > 0 {
+			if err := compression.compress(bodyBuffer, buffer.Bytes()); err != nil {
+				return fmt.Errorf("failed to compress body: %v", err)
 			}
+			req.Header.Set(fasthttp.HeaderContentEncoding, compression.Codec)
+			req.Header.Set("X-PayLoad-Compressed", util.ToString(true))
+		}
+		payloadSize = bodyBuffer.Len()
+	}
+
+	req.Header.Set("X-PayLoad-Size", util.ToString(payloadSize))
+
+	if auth := v.Request.authConfig(config); auth != nil {
+		if err := auth.apply(req, stream); err != nil {
+			return fmt.Errorf("failed to authenticate request: %v", err)
 		}
 	}
 
-	req.Header.Set("X-PayLoad-Size", util.ToString(bodyBuffer.Len()))
+	if compression != nil && compression.AcceptEncoding != codecNone {
+		req.Header.Set(fasthttp.HeaderAcceptEncoding, compression.AcceptEncoding)
+	}
+	return nil
+}
 
-	if bodyBuffer.Len() > 0 && compress {
-		req.Header.Set(fasthttp.HeaderAcceptEncoding, "gzip")
-		req.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
-		req.Header.Set("X-PayLoad-Compressed", util.ToString(true))
+// writeBody writes the body n times, runtime body line variables are
+// refreshed for each time, values are escaped by escapes if set.
+func writeBody(w io.Writer, body string, tmpl *fasttemplate.Template, escapes placeholderEscapes, n int, lineVariables *orderedVariables, runtimeVariables util.MapStr) {
+	if len(body) == 0 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		if tmpl == nil {
+			w.Write(util.UnsafeStringToBytes(body))
+			continue
+		}
+
+		lineVariables.put(runtimeVariables)
+
+		tmpl.ExecuteFuncStringExtend(w, escapes.tagFunc(runtimeVariables))
 	}
 }
 
@@ -486,16 +742,33 @@ func (cfg *LoadGenerator) Warmup(config *LoaderConfig) int {
 	defer defaultHTTPPool.ReleaseRequest(req)
 	resp := defaultHTTPPool.AcquireResponse()
 	defer defaultHTTPPool.ReleaseResponse(resp)
-	globalCtx := util.MapStr{}
+	globalCtx := util.MapStr{vuKey: -1}
+	var jar *cookieJar
+	if config.RunnerConfig.CookieJar {
+		jar = newCookieJar()
+		globalCtx[cookieJarKey] = jar
+	}
+	// Bind the first rows without consuming them
+	for _, s := range csvSources() {
+		globalCtx[s.name] = s.toMap(s.rows[0])
+	}
 	for _, v := range config.Requests {
-		v.prepareRequest(config, globalCtx, req)
+		if v.Request != nil {
+			if err := v.prepareRequest(config, globalCtx, req, vuOf(globalCtx)); err != nil {
+				log.Errorf("failed to prepare request [%v]: %v", v.String(), err)
+				panic(err)
+			}
+			if jar != nil {
+				jar.apply(req)
+			}
 
-		if !req.Validate() {
-			log.Errorf("invalid request: %v", req.String())
-			panic("invalid request")
+			if !req.Validate() {
+				log.Errorf("invalid request: %v", req.String())
+				panic("invalid request")
+			}
 		}
 
-		next, err := doRequest(config, globalCtx, req, resp, &v, loadStats, nil)
+		next, err := doItem(config, globalCtx, req, resp, &v, loadStats, nil)
 		for k, _ := range loadStats.StatusCode {
 			if len(config.RunnerConfig.ValidStatusCodesDuringWarmup) > 0 {
 				if util.ContainsInAnyInt32Array(k, config.RunnerConfig.ValidStatusCodesDuringWarmup) {
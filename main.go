@@ -36,6 +36,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jamiealquiza/tachymeter"
@@ -82,12 +83,20 @@ func init() {
 	flag.BoolVar(&compress, "compress", false, "Enable gzip compression for requests")
 	flag.BoolVar(&mixed, "mixed", false, "Enable mixed requests from YAML/DSL")
 	flag.IntVar(&totalRounds, "total-rounds", -1, "Number of rounds for each request configuration, default: -1 (unlimited)")
+	flag.Int64Var(&randomSeed, "seed", 0, "Seed of random values to reproduce a run, default: 0 (random seed)")
 	flag.StringVar(&dslFileToRun, "run", "", "Path to a DSL-based request file to execute")
 }
 
 func startLoader(cfg *LoaderConfig) *LoadStats {
 	defer log.Flush()
 
+	// Counters are global, clear the ones of the previous run
+	resetConnStats()
+	resetTLSStats()
+	resetDNSStats()
+	resetCompressionStats()
+	resetHTTP2Stats()
+
 	statsAggregator = make(chan *LoadStats, goroutines)
 	sigChan := make(chan os.Signal, 1)
 
@@ -107,7 +116,7 @@ func startLoader(cfg *LoaderConfig) *LoadStats {
 	// Initialize tachymeter.
 	timer := tachymeter.New(&tachymeter.Config{Size: cfg.RunnerConfig.MetricSampleSize})
 
-	loadGen := NewLoadGenerator(maxDuration, goroutines, statsAggregator, cfg.RunnerConfig.DisableHeaderNamesNormalizing)
+	loadGen := NewLoadGenerator(maxDuration, goroutines, statsAggregator, cfg)
 
 	leftDoc := reqLimit
 
@@ -164,6 +173,8 @@ func startLoader(cfg *LoaderConfig) *LoadStats {
 			aggStats.TotDuration += stats.TotDuration
 			aggStats.MaxRequestTime = util.MaxDuration(aggStats.MaxRequestTime, stats.MaxRequestTime)
 			aggStats.MinRequestTime = util.MinDuration(aggStats.MinRequestTime, stats.MinRequestTime)
+			aggStats.NumRedirects += stats.NumRedirects
+			aggStats.TotRedirectDuration += stats.TotRedirectDuration
 
 			for k, v := range stats.StatusCode {
 				oldV, ok := aggStats.StatusCode[k]
@@ -173,6 +184,26 @@ func startLoader(cfg *LoaderConfig) *LoadStats {
 				aggStats.StatusCode[k] = oldV + v
 			}
 
+			for k, v := range stats.WebSocket {
+				if aggStats.WebSocket == nil {
+					aggStats.WebSocket = map[string]*WebSocketStats{}
+				}
+				if _, ok := aggStats.WebSocket[k]; !ok {
+					aggStats.WebSocket[k] = &WebSocketStats{}
+				}
+				aggStats.WebSocket[k].merge(v)
+			}
+
+			for k, v := range stats.Stream {
+				if aggStats.Stream == nil {
+					aggStats.Stream = map[string]*StreamStats{}
+				}
+				if _, ok := aggStats.Stream[k]; !ok {
+					aggStats.Stream[k] = &StreamStats{}
+				}
+				aggStats.Stream[k].merge(v)
+			}
+
 			responders++
 		}
 	}
@@ -208,6 +239,7 @@ func startLoader(cfg *LoaderConfig) *LoadStats {
 
 	fmt.Println("\n[Loadgen Client Metrics]")
 
+	fmt.Printf("Seed:\t\t\t%v\n", cfg.RunnerConfig.Seed)
 	fmt.Printf("Requests/sec:\t\t%.2f\n", roughReqRate)
 
 	if !cfg.RunnerConfig.BenchmarkOnly && !cfg.RunnerConfig.NoSizeStats {
@@ -234,6 +266,106 @@ func startLoader(cfg *LoaderConfig) *LoadStats {
 		fmt.Printf("Status %v:\t\t%v\n", k, v)
 	}
 
+	if aggStats.NumRedirects > 0 {
+		fmt.Println("\n[Redirect Metrics]")
+		fmt.Printf("Redirects:\t\t%v\n", aggStats.NumRedirects)
+		fmt.Printf("Avg Redirect Time:\t%v\n", aggStats.TotRedirectDuration/time.Duration(aggStats.NumRedirects))
+	}
+
+	if compressed := atomic.LoadInt64(&compressionStats.requests); compressed > 0 {
+		uncompressedBytes := atomic.LoadInt64(&compressionStats.uncompressed)
+		compressedBytes := atomic.LoadInt64(&compressionStats.compressed)
+		compressTime := time.Duration(atomic.LoadInt64(&compressionStats.nanos))
+		fmt.Println("\n[Compression Metrics]")
+		fmt.Printf("Compressed Requests:\t%v\n", compressed)
+		fmt.Printf("Uncompressed Bytes:\t%v\n", util.ByteValue{Size: float64(uncompressedBytes)})
+		fmt.Printf("Compressed Bytes:\t%v\n", util.ByteValue{Size: float64(compressedBytes)})
+		if compressedBytes > 0 {
+			fmt.Printf("Compression Ratio:\t%.2f\n", float64(uncompressedBytes)/float64(compressedBytes))
+		}
+		fmt.Printf("Compression Time:\t%v\n", compressTime)
+		fmt.Printf("Avg Compression Time:\t%v\n", compressTime/time.Duration(compressed))
+	}
+
+	for name, ws := range aggStats.WebSocket {
+		fmt.Printf("\n[WebSocket Metrics: %s]\n", name)
+		fmt.Printf("Connections:\t\t%v\n", ws.Connections)
+		fmt.Printf("Connect Errors:\t\t%v\n", ws.ConnectErrors)
+		if ws.Connections > 0 {
+			fmt.Printf("Avg Connect Time:\t%v\n", ws.TotConnectTime/time.Duration(ws.Connections))
+		}
+		fmt.Printf("Messages Sent:\t\t%v\n", ws.MessagesSent)
+		fmt.Printf("Messages Received:\t%v\n", ws.MessagesReceived)
+		fmt.Printf("Messages/sec:\t\t%.2f\n", float64(ws.MessagesSent+ws.MessagesReceived)/finalDuration.Seconds())
+		if ws.RoundTrips > 0 {
+			fmt.Printf("Avg Round Trip:\t\t%v\n", ws.TotRoundTripTime/time.Duration(ws.RoundTrips))
+			fmt.Printf("Fastest Round Trip:\t%v\n", ws.MinRoundTripTime)
+			fmt.Printf("Slowest Round Trip:\t%v\n", ws.MaxRoundTripTime)
+		}
+	}
+
+	for name, s := range aggStats.Stream {
+		fmt.Printf("\n[Stream Metrics: %s]\n", name)
+		fmt.Printf("Streams:\t\t%v\n", s.Streams)
+		fmt.Printf("Timeouts:\t\t%v\n", s.Timeouts)
+		fmt.Printf("Events:\t\t\t%v\n", s.Events)
+		fmt.Printf("Invalid Events:\t\t%v\n", s.InvalidEvents)
+		fmt.Printf("Events/sec:\t\t%.2f\n", float64(s.Events)/finalDuration.Seconds())
+		if s.Streams > 0 {
+			fmt.Printf("Avg Events/Stream:\t%.2f\n", float64(s.Events)/float64(s.Streams))
+			fmt.Printf("Avg Stream Time:\t%v\n", s.TotStreamTime/time.Duration(s.Streams))
+		}
+		if s.FirstEvents > 0 {
+			fmt.Printf("Avg First Event:\t%v\n", s.TotFirstEventTime/time.Duration(s.FirstEvents))
+			fmt.Printf("Fastest First Event:\t%v\n", s.MinFirstEventTime)
+			fmt.Printf("Slowest First Event:\t%v\n", s.MaxFirstEventTime)
+		}
+		if s.Gaps > 0 {
+			fmt.Printf("Avg Event Gap:\t\t%v\n", s.TotGapTime/time.Duration(s.Gaps))
+			fmt.Printf("Max Event Gap:\t\t%v\n", s.MaxGapTime)
+		}
+	}
+
+	printVariableStats()
+
+	opened := atomic.LoadInt64(&connStats.opened)
+	exhausted := atomic.LoadInt64(&connStats.exhausted)
+	if opened > 0 || exhausted > 0 {
+		fmt.Println("\n[Connection Metrics]")
+		fmt.Printf("Connections Opened:\t%v\n", opened)
+		fmt.Printf("Connections Closed:\t%v\n", atomic.LoadInt64(&connStats.closed))
+		if opened > 0 {
+			fmt.Printf("Requests/Connection:\t%.2f\n", float64(aggStats.NumRequests)/float64(opened))
+		}
+		if exhausted > 0 {
+			fmt.Printf("Port Exhaustion Errors:\t%v\n", exhausted)
+		}
+	}
+	printDNSStats()
+
+	if balanced, ok := httpClient.(*balancedClient); ok {
+		balanced.pool.printStats(finalDuration)
+	}
+
+	if streams := atomic.LoadInt64(&http2Stats.streams); streams > 0 {
+		connections := atomic.LoadInt64(&http2Stats.connections)
+		fmt.Println("\n[HTTP/2 Metrics]")
+		fmt.Printf("Connections:\t\t%v\n", connections)
+		fmt.Printf("Streams:\t\t%v\n", streams)
+		if connections > 0 {
+			fmt.Printf("Streams/Connection:\t%.2f\n", float64(streams)/float64(connections))
+		}
+	}
+
+	if handshakes := atomic.LoadInt64(&tlsStats.handshakes); handshakes > 0 {
+		resumed := atomic.LoadInt64(&tlsStats.resumed)
+		fmt.Println("\n[TLS Metrics]")
+		fmt.Printf("Handshakes:\t\t%v\n", handshakes)
+		fmt.Printf("Full Handshakes:\t%v\n", handshakes-resumed)
+		fmt.Printf("Resumed Sessions:\t%v\n", resumed)
+		fmt.Printf("Resumption Rate:\t%.2f%%\n", float64(resumed)*100/float64(handshakes))
+	}
+
 	if !cfg.RunnerConfig.BenchmarkOnly && !cfg.RunnerConfig.NoStats {
 		// Rate outputs will be accurate.
 		fmt.Println("\n[Latency Metrics]")
@@ -446,6 +578,7 @@ func runLoaderConfig(config *LoaderConfig) int {
 	if err != nil {
 		panic(err)
 	}
+	defer config.close()
 
 	aggStats := startLoader(config)
 	if aggStats != nil {
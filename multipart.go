@@ -0,0 +1,253 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/framework/lib/fasttemplate"
+)
+
+// MultipartPart is a form field, or a file read from disk or generated with
+// templated content.
+type MultipartPart struct {
+	Name string `config:"name"`
+	// Value of the form field
+	Value string `config:"value"`
+	// Path of the file to upload, the file is streamed from disk
+	File string `config:"file"`
+	// Generated content of the file, repeated content_repeat_times times
+	Content            string `config:"content"`
+	ContentRepeatTimes int    `config:"content_repeat_times"`
+	// File name of the part, default: base name of file, or name
+	FileName string `config:"filename"`
+	// Content-Type of the part, default: application/octet-stream for files
+	ContentType string              `config:"content_type"`
+	Headers     []map[string]string `config:"headers"`
+
+	valueTemplate    *fasttemplate.Template
+	fileTemplate     *fasttemplate.Template
+	contentTemplate  *fasttemplate.Template
+	fileNameTemplate *fasttemplate.Template
+}
+
+func (p *MultipartPart) isFile() bool {
+	return p.File != "" || p.Content != ""
+}
+
+func (p *MultipartPart) init() error {
+	if p.Name == "" {
+		return fmt.Errorf("name of multipart part is required")
+	}
+	if p.File != "" && p.Content != "" {
+		return fmt.Errorf("multipart part [%s] can't have both file and content", p.Name)
+	}
+	if p.isFile() && p.Value != "" {
+		return fmt.Errorf("multipart part [%s] can't have both value and file", p.Name)
+	}
+	if p.ContentRepeatTimes <= 0 {
+		p.ContentRepeatTimes = 1
+	}
+	var err error
+	if p.valueTemplate, err = newTemplate(p.Value); err != nil {
+		return err
+	}
+	if p.fileTemplate, err = newTemplate(p.File); err != nil {
+		return err
+	}
+	if p.File != "" && p.fileTemplate == nil {
+		if _, err = os.Stat(p.File); err != nil {
+			return fmt.Errorf("invalid file of multipart part [%s]: %v", p.Name, err)
+		}
+	}
+	if p.contentTemplate, err = newTemplate(p.Content); err != nil {
+		return err
+	}
+	p.fileNameTemplate, err = newTemplate(p.FileName)
+	return err
+}
+
+// multipartSegment is a part of the body, either bytes or a file on disk.
+type multipartSegment struct {
+	data []byte
+	file string
+}
+
+// multipartBody is a rendered multipart/form-data body, files are read when
+// the body is sent.
+type multipartBody struct {
+	contentType string
+	size        int64
+	segments    []multipartSegment
+}
+
+// buildMultipartBody renders the parts, the sizes of files are checked to set
+// the Content-Length.
+func buildMultipartBody(parts []MultipartPart, runtimeVariables util.MapStr) (*multipartBody, error) {
+	buffer := &bytes.Buffer{}
+	writer := multipart.NewWriter(buffer)
+	body := &multipartBody{contentType: writer.FormDataContentType()}
+
+	type fileOffset struct {
+		offset int
+		file   string
+	}
+	var files []fileOffset
+	for i := range parts {
+		part := &parts[i]
+		header := textproto.MIMEHeader{}
+		file := renderTemplate(part.fileTemplate, part.File, runtimeVariables)
+		if part.isFile() {
+			fileName := renderTemplate(part.fileNameTemplate, part.FileName, runtimeVariables)
+			if fileName == "" && file != "" {
+				fileName = filepath.Base(file)
+			}
+			if fileName == "" {
+				fileName = part.Name
+			}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(part.Name), escapeQuotes(fileName)))
+			header.Set("Content-Type", "application/octet-stream")
+		} else {
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(part.Name)))
+		}
+		if part.ContentType != "" {
+			header.Set("Content-Type", part.ContentType)
+		}
+		for _, headers := range part.Headers {
+			for k, v := range headers {
+				header.Set(k, v)
+			}
+		}
+
+		if _, err := writer.CreatePart(header); err != nil {
+			return nil, err
+		}
+		switch {
+		case file != "":
+			info, err := os.Stat(file)
+			if err != nil {
+				return nil, err
+			}
+			body.size += info.Size()
+			files = append(files, fileOffset{offset: buffer.Len(), file: file})
+		case part.Content != "":
+			writeBody(buffer, part.Content, part.contentTemplate, nil, part.ContentRepeatTimes, nil, runtimeVariables)
+		default:
+			buffer.WriteString(renderTemplate(part.valueTemplate, part.Value, runtimeVariables))
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	data := buffer.Bytes()
+	offset := 0
+	for _, f := range files {
+		body.segments = append(body.segments, multipartSegment{data: data[offset:f.offset]}, multipartSegment{file: f.file})
+		offset = f.offset
+	}
+	body.segments = append(body.segments, multipartSegment{data: data[offset:]})
+	body.size += int64(len(data))
+	return body, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// setBody sets the body as the stream of req.
+func (body *multipartBody) setBody(req *fasthttp.Request) {
+	req.Header.SetContentType(body.contentType)
+	req.SetBodyStream(body.open(), int(body.size))
+}
+
+func (body *multipartBody) open() io.ReadCloser {
+	return &multipartReader{body: body}
+}
+
+// multipartReader reads the segments in order, only one file is opened at a
+// time.
+type multipartReader struct {
+	body   *multipartBody
+	i      int
+	offset int
+	file   *os.File
+}
+
+func (r *multipartReader) Read(p []byte) (int, error) {
+	for r.i < len(r.body.segments) {
+		segment := r.body.segments[r.i]
+		if segment.file == "" {
+			if r.offset < len(segment.data) {
+				n := copy(p, segment.data[r.offset:])
+				r.offset += n
+				return n, nil
+			}
+		} else {
+			if r.file == nil {
+				file, err := os.Open(segment.file)
+				if err != nil {
+					return 0, err
+				}
+				r.file = file
+			}
+			n, err := r.file.Read(p)
+			if n > 0 {
+				return n, nil
+			}
+			if err != io.EOF {
+				return 0, err
+			}
+			r.file.Close()
+			r.file = nil
+		}
+		r.i++
+		r.offset = 0
+	}
+	return 0, io.EOF
+}
+
+func (r *multipartReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,136 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"infini.sh/framework/core/util"
+)
+
+func TestBuildMultipartBody(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bulk.json")
+	fileData := strings.Repeat(`{"index":{}}`+"\n"+`{"id":1}`+"\n", 1000)
+	if err := os.WriteFile(file, []byte(fileData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := []MultipartPart{
+		{Name: "user", Value: "$[[user]]"},
+		{Name: "bulk", File: file, ContentType: "application/x-ndjson"},
+		{Name: "attachment", Content: "line $[[user]]\n", ContentRepeatTimes: 2, FileName: "$[[user]].txt",
+			Headers: []map[string]string{{"X-Part": "generated"}}},
+	}
+	for i := range parts {
+		if err := parts[i].init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := buildMultipartBody(parts, util.MapStr{"user": "medcl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := &multipartReader{body: body}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	if int64(len(data)) != body.size {
+		t.Fatalf("expected size %v, got %v", body.size, len(data))
+	}
+
+	_, params, err := mime.ParseMediaType(body.contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := multipart.NewReader(strings.NewReader(string(data)), params["boundary"])
+	expected := []struct {
+		name, fileName, contentType, content string
+	}{
+		{"user", "", "", "medcl"},
+		{"bulk", "bulk.json", "application/x-ndjson", fileData},
+		{"attachment", "medcl.txt", "application/octet-stream", "line medcl\nline medcl\n"},
+	}
+	for _, e := range expected {
+		part, err := form.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(part)
+		if part.FormName() != e.name || part.FileName() != e.fileName || part.Header.Get("Content-Type") != e.contentType || string(content) != e.content {
+			t.Errorf("unexpected part %s: %v, %s", e.name, part.Header, util.SubString(string(content), 0, 64))
+		}
+	}
+	if _, err = form.NextPart(); err != io.EOF {
+		t.Errorf("expected end of parts, got %v", err)
+	}
+}
+
+func TestMultipartPartInit(t *testing.T) {
+	invalid := []MultipartPart{
+		{Value: "no name"},
+		{Name: "both", File: "a.txt", Content: "a"},
+		{Name: "value", Value: "a", Content: "a"},
+		{Name: "missing", File: "not_found.txt"},
+	}
+	for _, part := range invalid {
+		if err := part.init(); err == nil {
+			t.Errorf("expected error of %+v", part)
+		}
+	}
+
+	// Templated files are checked when the body is built
+	parts := []MultipartPart{{Name: "upload", File: "$[[path]]"}}
+	if err := parts[0].init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildMultipartBody(parts, util.MapStr{"path": "not_found.txt"}); err == nil {
+		t.Error("expected error of missing file")
+	}
+}
+
+func TestMultipartCompression(t *testing.T) {
+	request := func() *Request {
+		return &Request{Method: "POST", Url: "http://localhost:9200/upload", Multipart: []MultipartPart{{Name: "user", Value: "medcl"}}}
+	}
+	config := &LoaderConfig{Requests: []RequestItem{{Request: request()}}}
+	config.Requests[0].Request.Compression = &CompressionConfig{Codec: codecGzip}
+	if err := config.Init(); err == nil {
+		t.Error("expected error of multipart with compression")
+	}
+
+	// Multipart bodies are sent uncompressed with the default compression
+	config = &LoaderConfig{RunnerConfig: RunnerConfig{Compression: &CompressionConfig{Codec: codecGzip}}, Requests: []RequestItem{{Request: request()}}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"infini.sh/framework/core/model"
+)
+
+// ProxyConfig sends all TCP connections through a HTTP CONNECT or SOCKS5
+// proxy.
+type ProxyConfig struct {
+	// Proxy url, e.g.: http://proxy:3128 or socks5://proxy:1080
+	Url       string           `config:"url"`
+	BasicAuth *model.BasicAuth `config:"basic_auth"`
+	// Hosts to connect directly, e.g.: localhost, .example.com, 10.0.0.0/8 or *
+	NoProxy []string `config:"no_proxy"`
+}
+
+type proxyDialer struct {
+	scheme   string
+	addr     string
+	username string
+	password string
+
+	noProxyAll   bool
+	noProxyHosts []string
+	noProxyNets  []*net.IPNet
+}
+
+// connProxy is used by dialConn if configured.
+var connProxy *proxyDialer
+
+func newProxyDialer(c *ProxyConfig) (*proxyDialer, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, err
+	}
+	d := &proxyDialer{scheme: u.Scheme, addr: u.Host}
+	switch d.scheme {
+	case "http":
+		if u.Port() == "" {
+			d.addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "socks5":
+		if u.Port() == "" {
+			d.addr = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy [%s]", c.Url)
+	}
+
+	if u.User != nil {
+		d.username = u.User.Username()
+		d.password, _ = u.User.Password()
+	}
+	if c.BasicAuth != nil && c.BasicAuth.Username != "" {
+		d.username = c.BasicAuth.Username
+		d.password = c.BasicAuth.Password.Get()
+	}
+
+	for _, host := range c.NoProxy {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "*" {
+			d.noProxyAll = true
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(host); err == nil {
+			d.noProxyNets = append(d.noProxyNets, ipNet)
+			continue
+		}
+		if host != "" {
+			d.noProxyHosts = append(d.noProxyHosts, host)
+		}
+	}
+	return d, nil
+}
+
+// useProxy returns false if the host of addr matches the no-proxy list,
+// domains also match their subdomains.
+func (d *proxyDialer) useProxy(addr string) bool {
+	if d.noProxyAll {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	if ip := net.ParseIP(host); ip != nil {
+		for _, ipNet := range d.noProxyNets {
+			if ipNet.Contains(ip) {
+				return false
+			}
+		}
+	}
+	for _, noProxy := range d.noProxyHosts {
+		if host == strings.TrimPrefix(noProxy, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(noProxy, ".")) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *proxyDialer) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if d.scheme == "socks5" {
+		var auth *proxy.Auth
+		if d.username != "" {
+			auth = &proxy.Auth{User: d.username, Password: d.password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", d.addr, auth, newDialer(timeout))
+		if err != nil {
+			return nil, err
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok || timeout <= 0 {
+			return dialer.Dial("tcp", addr)
+		}
+		// The deadline also covers the handshake, so a stuck proxy can't
+		// block the thread
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return contextDialer.DialContext(ctx, "tcp", addr)
+	}
+
+	conn, err := newDialer(timeout).Dial("tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if d.username != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(d.username+":"+d.password)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy [%s] refused to connect to [%s]: %s", d.addr, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the data buffered while reading the CONNECT response
+// first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,281 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// startConnectProxy starts a HTTP CONNECT proxy which requires the basic auth
+// of user:pass.
+func startConnectProxy(t *testing.T, tunnels *int32) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")) {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(tunnels, 1)
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	var tunnels int32
+	listener := startConnectProxy(t, &tunnels)
+	defer listener.Close()
+	defer func() { connProxy = nil }()
+
+	client := &fasthttp.Client{Dial: dialConn}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(server.URL)
+
+	var err error
+	connProxy, err = newProxyDialer(&ProxyConfig{Url: "http://user:pass@" + listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body()) != "hello" || atomic.LoadInt32(&tunnels) != 1 {
+		t.Errorf("unexpected response: %s, tunnels: %v", resp.Body(), tunnels)
+	}
+
+	connProxy, _ = newProxyDialer(&ProxyConfig{Url: "http://" + listener.Addr().String()})
+	if _, err = dialConn(server.Listener.Addr().String()); err == nil {
+		t.Error("expected error without proxy auth")
+	}
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy which requires the username and
+// password of user:pass.
+func startSOCKS5Proxy(t *testing.T, tunnels *int32) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				read := func(n int) []byte {
+					b := make([]byte, n)
+					if _, err := io.ReadFull(reader, b); err != nil {
+						return nil
+					}
+					return b
+				}
+				// Greeting, select the username/password method
+				header := read(2)
+				if header == nil || read(int(header[1])) == nil {
+					return
+				}
+				conn.Write([]byte{5, 2})
+				version := read(2)
+				if version == nil {
+					return
+				}
+				user := string(read(int(version[1])))
+				passLen := read(1)
+				if passLen == nil {
+					return
+				}
+				if pass := string(read(int(passLen[0]))); user != "user" || pass != "pass" {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				// CONNECT request
+				request := read(4)
+				if request == nil {
+					return
+				}
+				var host string
+				switch request[3] {
+				case 1:
+					host = net.IP(read(4)).String()
+				case 3:
+					if n := read(1); n != nil {
+						host = string(read(int(n[0])))
+					}
+				case 4:
+					host = net.IP(read(16)).String()
+				}
+				port := read(2)
+				if port == nil {
+					return
+				}
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(tunnels, 1)
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(target, reader)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	var tunnels int32
+	listener := startSOCKS5Proxy(t, &tunnels)
+	defer listener.Close()
+	defer func() { connProxy = nil }()
+
+	client := &fasthttp.Client{Dial: dialConn}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(server.URL)
+
+	var err error
+	connProxy, err = newProxyDialer(&ProxyConfig{Url: "socks5://user:pass@" + listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body()) != "hello" || atomic.LoadInt32(&tunnels) != 1 {
+		t.Errorf("unexpected response: %s, tunnels: %v", resp.Body(), tunnels)
+	}
+
+	connProxy, _ = newProxyDialer(&ProxyConfig{Url: "socks5://user:wrong@" + listener.Addr().String()})
+	if _, err = dialConn(server.Listener.Addr().String()); err == nil {
+		t.Error("expected error of wrong proxy auth")
+	}
+
+	// A proxy which never answers the handshake
+	stuck, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stuck.Close()
+	go func() {
+		for {
+			conn, err := stuck.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	oldDialTimeout := dialTimeout
+	dialTimeout = 1
+	defer func() { dialTimeout = oldDialTimeout }()
+	connProxy, _ = newProxyDialer(&ProxyConfig{Url: "socks5://" + stuck.Addr().String()})
+	start := time.Now()
+	if _, err = dialConn(server.Listener.Addr().String()); err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("expected timeout of the handshake, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	d, err := newProxyDialer(&ProxyConfig{
+		Url:     "socks5://proxy",
+		NoProxy: []string{"localhost", ".example.com", "10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.addr != "proxy:1080" {
+		t.Errorf("unexpected proxy address: %v", d.addr)
+	}
+	for addr, expected := range map[string]bool{
+		"localhost:9200":      false,
+		"es.example.com:9200": false,
+		"example.com:9200":    false,
+		"10.1.2.3:9200":       false,
+		"11.1.2.3:9200":       true,
+		"example.org:9200":    true,
+	} {
+		if d.useProxy(addr) != expected {
+			t.Errorf("expected useProxy of %v to be %v", addr, expected)
+		}
+	}
+
+	if _, err := newProxyDialer(&ProxyConfig{Url: "ftp://proxy"}); err == nil {
+		t.Error("expected error of unsupported proxy")
+	}
+}
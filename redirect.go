@@ -0,0 +1,182 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// RedirectConfig follows 3xx responses, so that assertions see the final
+// response.
+type RedirectConfig struct {
+	Enabled bool `config:"enabled"`
+	// Max number of redirects to follow, default: 10
+	MaxRedirects int `config:"max_redirects"`
+	// Send 307 and 308 redirects as GET without body like 301-303, default:
+	// false (keep the method and body)
+	RewriteMethod bool `config:"rewrite_method"`
+}
+
+// redirectHop is a redirect response followed by doRequest.
+type redirectHop struct {
+	url      string
+	status   int
+	location string
+	duration time.Duration
+}
+
+func (hop redirectHop) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"url":      hop.url,
+		"status":   hop.status,
+		"location": hop.location,
+		"elapsed":  int64(hop.duration / time.Millisecond),
+	}
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectConfig returns the redirect config of the request, or the runner
+// default.
+func (item *RequestItem) redirectConfig(config *LoaderConfig) *RedirectConfig {
+	if item.Request != nil && item.Request.FollowRedirects != nil {
+		return item.Request.FollowRedirects
+	}
+	return config.RunnerConfig.FollowRedirects
+}
+
+// credentialHeaders are dropped once a redirect leaves the host of the
+// request, along with the header of the auth.
+var credentialHeaders = []string{
+	fasthttp.HeaderAuthorization,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderCookie,
+	"X-Amz-Date",
+	"X-Amz-Content-Sha256",
+	"X-Amz-Security-Token",
+}
+
+// followRedirects sends requests to the location of resp until a non-redirect
+// response, resp is replaced by the final response. The first hop starts
+// with the response of req, its duration is firstDuration. Cookies of the
+// cookie jar in globalCtx are sent and updated by each hop.
+func followRedirects(client requestDoer, redirect *RedirectConfig, auth *AuthConfig, globalCtx util.MapStr, req *fasthttp.Request, resp *fasthttp.Response, firstDuration time.Duration) (hops []redirectHop, err error) {
+	maxRedirects := redirect.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = 10
+	}
+
+	hopReq := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(hopReq)
+	req.CopyTo(hopReq)
+
+	jar := cookieJarOf(globalCtx)
+	host := string(req.URI().Host())
+	duration := firstDuration
+	for isRedirect(resp.StatusCode()) {
+		if jar != nil {
+			jar.update(globalCtx, hopReq, resp)
+		}
+		location := string(resp.Header.Peek(fasthttp.HeaderLocation))
+		hops = append(hops, redirectHop{
+			url:      hopReq.URI().String(),
+			status:   resp.StatusCode(),
+			location: location,
+			duration: duration,
+		})
+		if location == "" {
+			return hops, nil
+		}
+		if len(hops) > maxRedirects {
+			return hops, fmt.Errorf("stopped after %v redirects", maxRedirects)
+		}
+
+		statusCode := resp.StatusCode()
+		keepMethod := (statusCode == fasthttp.StatusTemporaryRedirect || statusCode == fasthttp.StatusPermanentRedirect) && !redirect.RewriteMethod
+		if !keepMethod && !hopReq.Header.IsHead() {
+			hopReq.Header.SetMethod(fasthttp.MethodGet)
+			hopReq.ResetBody()
+			hopReq.Header.Del(fasthttp.HeaderContentType)
+			hopReq.Header.Del(fasthttp.HeaderContentEncoding)
+			hopReq.Header.SetContentLength(0)
+		} else if body := loadBodyStream(req); body != nil {
+			// The body stream was consumed by the last hop
+			body.setBody(hopReq)
+		}
+		hopReq.URI().Update(location)
+		hopReq.SetHostBytes(hopReq.URI().Host())
+		if string(hopReq.URI().Host()) != host {
+			for _, header := range credentialHeaders {
+				hopReq.Header.Del(header)
+			}
+			if auth != nil && auth.headerName != "" {
+				hopReq.Header.Del(auth.headerName)
+			}
+		} else if auth != nil && auth.Type == authAWSSigV4 {
+			// The signature covers the path and query of the hop
+			var body bodyStream
+			if keepMethod {
+				body = loadBodyStream(req)
+			}
+			if err = auth.apply(hopReq, body); err != nil {
+				return hops, err
+			}
+		}
+		if jar != nil {
+			hopReq.Header.Del(fasthttp.HeaderCookie)
+			jar.apply(hopReq)
+		}
+
+		resp.Reset()
+		start := time.Now()
+		if timeout > 0 {
+			err = client.DoTimeout(hopReq, resp, time.Duration(timeout)*time.Second)
+		} else {
+			err = client.Do(hopReq, resp)
+		}
+		duration = time.Since(start)
+		if err != nil {
+			return hops, err
+		}
+	}
+	if jar != nil {
+		jar.update(globalCtx, hopReq, resp)
+	}
+	return hops, nil
+}
@@ -0,0 +1,242 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"infini.sh/framework/core/util"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/found":
+			http.Redirect(w, r, "/temporary", http.StatusFound)
+		case "/temporary":
+			http.Redirect(w, r, "/final", http.StatusTemporaryRedirect)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(r.Method + " " + string(body)))
+		}
+	}))
+	defer server.Close()
+
+	client := &fasthttp.Client{}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	do := func(path string, redirect *RedirectConfig) ([]redirectHop, error) {
+		req.Reset()
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(server.URL + path)
+		req.SetBodyString("hello")
+		if err := client.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		return followRedirects(client, redirect, nil, nil, req, resp, 0)
+	}
+
+	hops, err := do("/temporary", &RedirectConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 1 || hops[0].status != http.StatusTemporaryRedirect || string(resp.Body()) != "POST hello" {
+		t.Errorf("unexpected result of 307: %+v, %s", hops, resp.Body())
+	}
+
+	hops, err = do("/found", &RedirectConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 || hops[0].location != "/temporary" || string(resp.Body()) != "GET " {
+		t.Errorf("unexpected result of 302: %+v, %s", hops, resp.Body())
+	}
+
+	if hops, err = do("/loop", &RedirectConfig{Enabled: true, MaxRedirects: 3}); err == nil || len(hops) != 4 {
+		t.Errorf("expected error after 3 redirects, got %v hops, error: %v", len(hops), err)
+	}
+}
+
+func TestRedirectCredentialsAndCookies(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("auth=" + r.Header.Get("Authorization") + ",api_key=" + r.Header.Get("X-API-Key") + ",cookie=" + r.Header.Get("Cookie")))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+			http.Redirect(w, r, "/home", http.StatusFound)
+		case "/home":
+			if _, err := r.Cookie("sid"); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// Cookies are not isolated by ports, use another host name
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/away", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &fasthttp.Client{}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(server.URL + "/login")
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("X-API-Key", "abc")
+	if err := client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	jar := newCookieJar()
+	globalCtx := util.MapStr{cookieJarKey: jar}
+	auth := &AuthConfig{Type: authAPIKey, Key: "abc"}
+	if err := auth.init(); err != nil {
+		t.Fatal(err)
+	}
+	hops, err := followRedirects(client, &RedirectConfig{Enabled: true}, auth, globalCtx, req, resp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 || string(resp.Body()) != "auth=,api_key=,cookie=" {
+		t.Errorf("unexpected result of cross-host redirect: %+v, %s", hops, resp.Body())
+	}
+	req.SetRequestURI(server.URL + "/")
+	req.Header.Del(fasthttp.HeaderCookie)
+	jar.apply(req)
+	if v := string(req.Header.Cookie("sid")); v != "abc" {
+		t.Errorf("expected cookie of the redirect response, got %q", v)
+	}
+}
+
+func TestRedirectBodyStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/temporary" {
+			io.Copy(io.Discard, r.Body)
+			http.Redirect(w, r, "/final", http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + string(body)))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := &Request{}
+	var err error
+	if v.bodyFile, err = newBodyFile(path, bodyFileWhole, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer v.bodyFile.file.Close()
+
+	client := &fasthttp.Client{}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI(server.URL + "/temporary")
+	section := v.bodyFile.chunk(0)
+	section.setBody(req)
+	bodyStreams.Store(req, section)
+	defer bodyStreams.Delete(req)
+	if err = client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	hops, err := followRedirects(client, &RedirectConfig{Enabled: true}, nil, nil, req, resp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 1 || string(resp.Body()) != "POST hello" {
+		t.Errorf("unexpected result of 307 with body stream: %+v, %s", hops, resp.Body())
+	}
+}
+
+func TestRedirectSigV4(t *testing.T) {
+	auth := &AuthConfig{Type: authAWSSigV4, AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Region: "us-east-1", Service: "es"}
+	if err := auth.init(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new?q=1", http.StatusFound)
+			return
+		}
+		// Sign the received request again to verify the signature
+		now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		signed := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(signed)
+		signed.Header.SetMethod(r.Method)
+		signed.SetRequestURI("http://" + r.Host + r.URL.RequestURI())
+		auth.signV4(signed, r.Header.Get("X-Amz-Content-Sha256"), now)
+		if string(signed.Header.Peek(fasthttp.HeaderAuthorization)) != r.Header.Get("Authorization") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	client := &fasthttp.Client{}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(server.URL + "/old")
+	if err := auth.apply(req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	hops, err := followRedirects(client, &RedirectConfig{Enabled: true}, auth, nil, req, resp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 1 || resp.StatusCode() != http.StatusOK {
+		t.Errorf("unexpected result of signed redirect: %+v, %v", hops, resp.StatusCode())
+	}
+}
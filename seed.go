@@ -0,0 +1,102 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// randomSeed is set by `-seed`, overrides `runner.seed`.
+var randomSeed int64
+
+var (
+	runSeed int64
+
+	vuRandsLock sync.RWMutex
+	vuRands     = map[int]*rand.Rand{}
+)
+
+// initSeed sets the seed of the run and resets the random sources of VUs, a
+// random seed is picked if not set.
+func initSeed(seed int64) int64 {
+	if randomSeed != 0 {
+		seed = randomSeed
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	runSeed = seed
+
+	vuRandsLock.Lock()
+	vuRands = map[int]*rand.Rand{}
+	vuRandsLock.Unlock()
+	return seed
+}
+
+// mixSeed derives a seed from the seed and n with splitmix64, so derived
+// seeds are not correlated.
+func mixSeed(seed int64, n uint64) int64 {
+	z := uint64(seed) + (n+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// vuRand returns the random source of the VU, values of the VU are generated
+// in the same sequence with the same seed. The source is only used by the
+// goroutine of the VU.
+func vuRand(vu int) *rand.Rand {
+	vuRandsLock.RLock()
+	r, ok := vuRands[vu]
+	vuRandsLock.RUnlock()
+	if ok {
+		return r
+	}
+
+	vuRandsLock.Lock()
+	defer vuRandsLock.Unlock()
+	if r, ok = vuRands[vu]; !ok {
+		r = rand.New(rand.NewSource(mixSeed(runSeed, uint64(int64(vu)))))
+		vuRands[vu] = r
+	}
+	return r
+}
+
+// variableSeed returns the seed of the variable, derived from the seed of the
+// run and the name of the variable if not set.
+func variableSeed(x *Variable) int64 {
+	if x.Seed != 0 {
+		return x.Seed
+	}
+	h := fnv.New64a()
+	h.Write([]byte(x.Name))
+	return mixSeed(runSeed, h.Sum64())
+}
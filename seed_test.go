@@ -0,0 +1,123 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func seededValues(t *testing.T, seed int64, vus []int) map[int][]string {
+	config := &LoaderConfig{RunnerConfig: RunnerConfig{Seed: seed}, Variable: []Variable{
+		{Name: "id", Type: "range", From: 1, To: 1000000},
+		{Name: "ip", Type: "list", Data: []string{"1", "2", "3", "4", "5", "6", "7", "8"}},
+		{Name: "user", Type: "person_name"},
+		{Name: "latency", Type: "normal", Mean: 100, Stddev: 20},
+	}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if config.RunnerConfig.Seed != seed {
+		t.Fatalf("unexpected seed: %v", config.RunnerConfig.Seed)
+	}
+	values := map[int][]string{}
+	for i := 0; i < 20; i++ {
+		for _, vu := range vus {
+			for _, name := range []string{"id", "ip", "user", "latency"} {
+				values[vu] = append(values[vu], getVariable(name, vu))
+			}
+		}
+	}
+	return values
+}
+
+// seededBodies renders the body of a request with runtime variables that
+// share the random source of the VU.
+func seededBodies(t *testing.T, seed int64) []string {
+	config := &LoaderConfig{RunnerConfig: RunnerConfig{Seed: seed}, Variable: []Variable{
+		{Name: "id", Type: "range", From: 1, To: 1000000},
+		{Name: "ip", Type: "list", Data: []string{"1", "2", "3", "4", "5", "6", "7", "8"}},
+	}, Requests: []RequestItem{{Request: &Request{
+		Method:           "POST",
+		Url:              "http://localhost:9200/_bulk",
+		Body:             "$[[a]] $[[b]] $[[c]] $[[d]] $[[e]]",
+		RuntimeVariables: map[string]string{"a": "id", "b": "id", "c": "ip", "d": "id", "e": "ip"},
+	}}}}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	var bodies []string
+	for i := 0; i < 20; i++ {
+		if err := config.Requests[0].prepareRequest(config, util.MapStr{vuKey: 0}, req, 0); err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(req.Body()))
+	}
+	return bodies
+}
+
+func TestSeed(t *testing.T) {
+	x := seededValues(t, 42, []int{0, 1})
+	// the values of each VU are the same however VUs are interleaved
+	y := seededValues(t, 42, []int{1, 0})
+	for _, vu := range []int{0, 1} {
+		for i := range x[vu] {
+			if x[vu][i] != y[vu][i] {
+				t.Fatalf("values of VU [%v] differ: %s, %s", vu, x[vu][i], y[vu][i])
+			}
+		}
+	}
+
+	same := true
+	for i := range x[0] {
+		same = same && x[0][i] == x[1][i]
+	}
+	if same {
+		t.Error("values of VUs should differ")
+	}
+
+	// runtime variables are evaluated in the same order
+	for i := 0; i < 5; i++ {
+		bodies, expected := seededBodies(t, 42), seededBodies(t, 42)
+		for j := range expected {
+			if bodies[j] != expected[j] {
+				t.Fatalf("bodies of runtime variables differ: %s, %s", bodies[j], expected[j])
+			}
+		}
+	}
+
+	randomSeed = 7
+	defer func() { randomSeed = 0 }()
+	if seed := initSeed(42); seed != 7 {
+		t.Errorf("expected seed of -seed, got %v", seed)
+	}
+	randomSeed = 0
+	if seed := initSeed(0); seed == 0 {
+		t.Error("expected a random seed")
+	}
+}
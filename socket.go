@@ -0,0 +1,300 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/jamiealquiza/tachymeter"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasttemplate"
+)
+
+const (
+	networkTCP = "tcp"
+	networkUDP = "udp"
+)
+
+// SocketRequest sends raw payloads over TCP or UDP.
+type SocketRequest struct {
+	// Server address as host:port, default: host of the default endpoint
+	Address string `config:"address"`
+	Body    string `config:"body"`
+
+	RepeatBodyNTimes         int               `config:"body_repeat_times"`
+	RuntimeVariables         map[string]string `config:"runtime_variables"`
+	RuntimeBodyLineVariables map[string]string `config:"runtime_body_line_variables"`
+
+	// Open a new connection for every message instead of reusing connections
+	ConnectPerMessage bool `config:"connect_per_message"`
+	// Read the response until the delimiter, e.g.: "\n", leave it empty to skip
+	// reading responses. A UDP response is a single datagram.
+	ReadUntil string `config:"read_until"`
+	// Max size of the response to read, default: 65536
+	MaxResponseSize int `config:"max_response_size"`
+
+	network           string
+	bodyTemplate      *fasttemplate.Template
+	runtimeVariables  *orderedVariables
+	bodyLineVariables *orderedVariables
+
+	lock  sync.Mutex
+	conns []*socketConn
+}
+
+type socketConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (s *SocketRequest) init(network string) error {
+	s.network = network
+	if s.RepeatBodyNTimes <= 0 {
+		s.RepeatBodyNTimes = 1
+	}
+	if s.MaxResponseSize <= 0 {
+		s.MaxResponseSize = 65536
+	}
+	s.runtimeVariables = newOrderedVariables(s.RuntimeVariables)
+	s.bodyLineVariables = newOrderedVariables(s.RuntimeBodyLineVariables)
+	var err error
+	s.bodyTemplate, err = newTemplate(s.Body)
+	return err
+}
+
+func (s *SocketRequest) resolveAddress(config *LoaderConfig) string {
+	if s.Address != "" {
+		return s.Address
+	}
+	endpoint, err := config.RunnerConfig.parseDefaultEndpoint()
+	if err != nil {
+		return ""
+	}
+	return hostAddr(endpoint)
+}
+
+// getConn takes an idle connection, or dials a new one.
+func (s *SocketRequest) getConn(address string) (*socketConn, error) {
+	if !s.ConnectPerMessage {
+		s.lock.Lock()
+		if n := len(s.conns); n > 0 {
+			conn := s.conns[n-1]
+			s.conns = s.conns[:n-1]
+			s.lock.Unlock()
+			return conn, nil
+		}
+		s.lock.Unlock()
+	}
+
+	var conn net.Conn
+	var err error
+	if s.network == networkUDP {
+		conn, err = dialUDP(address)
+	} else {
+		conn, err = dialConn(address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &socketConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// putConn returns the connection for reuse, or closes it if it's broken or
+// not reusable.
+func (s *SocketRequest) putConn(conn *socketConn, err error) {
+	if err != nil || s.ConnectPerMessage {
+		conn.Close()
+		return
+	}
+	s.lock.Lock()
+	s.conns = append(s.conns, conn)
+	s.lock.Unlock()
+}
+
+// render renders the payloads to send, each repeated body is a datagram of
+// UDP.
+func (s *SocketRequest) render(runtimeVariables util.MapStr) [][]byte {
+	if s.network != networkUDP {
+		buffer := bytes.Buffer{}
+		writeBody(&buffer, s.Body, s.bodyTemplate, nil, s.RepeatBodyNTimes, s.bodyLineVariables, runtimeVariables)
+		return [][]byte{buffer.Bytes()}
+	}
+
+	payloads := make([][]byte, 0, s.RepeatBodyNTimes)
+	for i := 0; i < s.RepeatBodyNTimes; i++ {
+		buffer := bytes.Buffer{}
+		writeBody(&buffer, s.Body, s.bodyTemplate, nil, 1, s.bodyLineVariables, runtimeVariables)
+		payloads = append(payloads, buffer.Bytes())
+	}
+	return payloads
+}
+
+// send writes the payloads to conn and returns the number of bytes written.
+func (s *SocketRequest) send(conn *socketConn, payloads [][]byte) (int, error) {
+	if writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(time.Duration(writeTimeout) * time.Second))
+	}
+	size := 0
+	for _, payload := range payloads {
+		n, err := conn.Write(payload)
+		size += n
+		if err != nil {
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+func (s *SocketRequest) receive(conn *socketConn) ([]byte, error) {
+	if readTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(readTimeout) * time.Second))
+	}
+	if s.network == networkUDP {
+		buf := make([]byte, s.MaxResponseSize)
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+
+	delimiter := []byte(s.ReadUntil)
+	response := []byte{}
+	for !bytes.HasSuffix(response, delimiter) {
+		if len(response) >= s.MaxResponseSize {
+			return response, fmt.Errorf("response exceeds %d bytes without delimiter", s.MaxResponseSize)
+		}
+		b, err := conn.reader.ReadByte()
+		if err != nil {
+			return response, err
+		}
+		response = append(response, b)
+	}
+	return response, nil
+}
+
+func doSocket(config *LoaderConfig, globalCtx util.MapStr, item *RequestItem, socket *SocketRequest, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
+	runtimeVariables := util.MapStr{}
+	runtimeVariables.Update(globalCtx)
+	socket.runtimeVariables.put(runtimeVariables)
+
+	payloads := socket.render(runtimeVariables)
+	if exhaustedIn(globalCtx) {
+		return false, nil
+	}
+
+	address := socket.resolveAddress(config)
+	var response []byte
+	reqSize := 0
+	start := time.Now()
+	conn, err := socket.getConn(address)
+	if err == nil {
+		reqSize, err = socket.send(conn, payloads)
+		if err == nil && socket.ReadUntil != "" {
+			response, err = socket.receive(conn)
+		}
+		socket.putConn(conn, err)
+	}
+	duration := time.Since(start)
+
+	// Status codes are borrowed from HTTP, so that the results are reported
+	// the same way
+	statusCode := 200
+	if err != nil {
+		statusCode = 0
+	}
+
+	if !config.RunnerConfig.BenchmarkOnly && timer != nil {
+		timer.AddTime(duration)
+	}
+
+	if !config.RunnerConfig.NoStats {
+		if config.RunnerConfig.DurationInUs {
+			stats.Timing(socket.network, "duration_in_us", duration.Microseconds())
+		} else {
+			stats.Timing(socket.network, "duration", duration.Milliseconds())
+		}
+		stats.Increment(socket.network, "total")
+
+		if err != nil {
+			loadStats.NumErrs++
+			loadStats.NumAssertInvalid++
+		}
+
+		if !config.RunnerConfig.NoSizeStats {
+			loadStats.TotReqSize += int64(reqSize)
+			loadStats.TotRespSize += int64(len(response))
+		}
+
+		loadStats.NumRequests++
+		loadStats.TotDuration += duration
+		loadStats.MaxRequestTime = util.MaxDuration(duration, loadStats.MaxRequestTime)
+		loadStats.MinRequestTime = util.MinDuration(duration, loadStats.MinRequestTime)
+		loadStats.StatusCode[statusCode] += 1
+	}
+
+	if config.RunnerConfig.BenchmarkOnly {
+		return true, err
+	}
+
+	if config.RunnerConfig.LogRequests || util.ContainsInAnyInt32Array(statusCode, config.RunnerConfig.LogStatusCodes) {
+		log.Infof("[%v] %v, %v bytes sent", socket.network, address, reqSize)
+		log.Infof("error: %v, response: %v", err, util.SubString(string(response), 0, 512))
+	}
+
+	if err != nil {
+		return true, err
+	}
+
+	if item.Register != nil || item.Assert != nil {
+		event := util.MapStr{
+			"_ctx": map[string]interface{}{
+				"response": map[string]interface{}{
+					"status":      statusCode,
+					"body":        string(response),
+					"body_length": len(response),
+				},
+				"elapsed": int64(duration / time.Millisecond),
+			},
+		}
+		putBodyJson(event, "_ctx.response.body_json", response)
+		if next, _ := item.registerAndAssert(config, globalCtx, event, len(response), loadStats); !next {
+			return false, nil
+		}
+	}
+
+	if item.Sleep != nil {
+		time.Sleep(time.Duration(item.Sleep.SleepInMilliSeconds) * time.Millisecond)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,198 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infini.sh/framework/core/util"
+)
+
+func TestTCPReuseConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan int, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- 1
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte("ok " + line))
+				}
+			}()
+		}
+	}()
+
+	config := &LoaderConfig{
+		Variable: []Variable{{Name: "id", Type: "sequence"}},
+		Requests: []RequestItem{{TCP: &SocketRequest{
+			Address:   listener.Addr().String(),
+			Body:      "cpu,host=$[[id]] value=1\n",
+			ReadUntil: "\n",
+		}}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	for i := 0; i < 3; i++ {
+		if _, err := doItem(config, util.MapStr{}, nil, nil, &config.Requests[0], loadStats, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(accepted) != 1 {
+		t.Errorf("expected 1 connection, got %v", len(accepted))
+	}
+	if loadStats.StatusCode[200] != 3 || loadStats.TotRespSize == 0 {
+		t.Errorf("unexpected stats: %+v", loadStats)
+	}
+}
+
+func TestUDPDatagramPerRepetition(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	config := &LoaderConfig{
+		Requests: []RequestItem{{UDP: &SocketRequest{
+			Address:          conn.LocalAddr().String(),
+			Body:             "<14>loadgen: hello",
+			RepeatBodyNTimes: 3,
+		}}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	if _, err := doItem(config, util.MapStr{}, nil, nil, &config.Requests[0], loadStats, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 3; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "<14>loadgen: hello" {
+			t.Errorf("unexpected datagram: %s", buf[:n])
+		}
+	}
+}
+
+func TestUDPResolve(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	connResolver, err = newHostResolver([]string{"syslog.example.com:" + port + ":127.0.0.1"}, "", time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { connResolver = nil }()
+	opened := atomic.LoadInt64(&connStats.opened)
+
+	config := &LoaderConfig{
+		Requests: []RequestItem{{UDP: &SocketRequest{
+			Address:           "syslog.example.com:" + port,
+			Body:              "<14>loadgen: hello",
+			ConnectPerMessage: true,
+		}}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	if _, err := doItem(config, util.MapStr{}, nil, nil, &config.Requests[0], loadStats, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := conn.ReadFrom(buf); err != nil || string(buf[:n]) != "<14>loadgen: hello" {
+		t.Errorf("unexpected datagram: %s, %v", buf[:n], err)
+	}
+	if atomic.LoadInt64(&connStats.opened) != opened+1 {
+		t.Error("expected the UDP socket to be counted")
+	}
+}
+
+func TestSocketStopsOnExhaustedValues(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	config := &LoaderConfig{
+		Variable: []Variable{{Name: "host", Type: "list", Data: []string{"a", "b"}, Order: orderSequential, OnExhaust: exhaustStop}},
+		Requests: []RequestItem{{UDP: &SocketRequest{
+			Address: conn.LocalAddr().String(),
+			Body:    "host=$[[host]]",
+		}}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	globalCtx := util.MapStr{vuKey: 0}
+	for i, expected := range []bool{true, true, false} {
+		next, err := doItem(config, globalCtx, nil, nil, &config.Requests[0], loadStats, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != expected {
+			t.Errorf("#%v: expected next %v, got %v", i, expected, next)
+		}
+	}
+	if loadStats.NumRequests != 2 {
+		t.Errorf("expected 2 requests, got %v", loadStats.NumRequests)
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/jamiealquiza/tachymeter"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const (
+	streamSSE    = "sse"
+	streamNDJSON = "ndjson"
+)
+
+// StreamConfig reads the response as a stream of events, Server-Sent Events
+// or chunked NDJSON lines.
+type StreamConfig struct {
+	// Name of the stream in the summary, default: url
+	Name string `config:"name"`
+	// sse (default) or ndjson
+	Format string `config:"format"`
+	// Check each event, events are accessed by `_ctx.response.body`,
+	// `_ctx.response.body_json`, `_ctx.response.event` and `_ctx.response.id`
+	EventAssert *conditions.Config `config:"event_assert"`
+	// Stop reading the stream once an event matches, the stream is invalid if
+	// it ends without a matching event
+	StopOn *conditions.Config `config:"stop_on"`
+	// Max duration of the stream, default: 60000
+	TimeoutInMilliSeconds int64 `config:"timeout_in_milli_seconds"`
+
+	eventAssert conditions.Condition
+	stopOn      conditions.Condition
+}
+
+type StreamStats struct {
+	Streams           int
+	Timeouts          int
+	Events            int
+	InvalidEvents     int
+	FirstEvents       int
+	TotFirstEventTime time.Duration
+	MinFirstEventTime time.Duration
+	MaxFirstEventTime time.Duration
+	Gaps              int
+	TotGapTime        time.Duration
+	MaxGapTime        time.Duration
+	TotStreamTime     time.Duration
+}
+
+func (s *StreamStats) addFirstEvent(duration time.Duration) {
+	if s.FirstEvents == 0 || duration < s.MinFirstEventTime {
+		s.MinFirstEventTime = duration
+	}
+	s.MaxFirstEventTime = util.MaxDuration(duration, s.MaxFirstEventTime)
+	s.TotFirstEventTime += duration
+	s.FirstEvents++
+}
+
+func (s *StreamStats) addGap(duration time.Duration) {
+	s.MaxGapTime = util.MaxDuration(duration, s.MaxGapTime)
+	s.TotGapTime += duration
+	s.Gaps++
+}
+
+func (s *StreamStats) merge(other *StreamStats) {
+	if other.FirstEvents > 0 && (s.FirstEvents == 0 || other.MinFirstEventTime < s.MinFirstEventTime) {
+		s.MinFirstEventTime = other.MinFirstEventTime
+	}
+	s.MaxFirstEventTime = util.MaxDuration(other.MaxFirstEventTime, s.MaxFirstEventTime)
+	s.MaxGapTime = util.MaxDuration(other.MaxGapTime, s.MaxGapTime)
+	s.Streams += other.Streams
+	s.Timeouts += other.Timeouts
+	s.Events += other.Events
+	s.InvalidEvents += other.InvalidEvents
+	s.FirstEvents += other.FirstEvents
+	s.TotFirstEventTime += other.TotFirstEventTime
+	s.Gaps += other.Gaps
+	s.TotGapTime += other.TotGapTime
+	s.TotStreamTime += other.TotStreamTime
+}
+
+func (loadStats *LoadStats) streamStats(name string) *StreamStats {
+	if loadStats.Stream == nil {
+		loadStats.Stream = map[string]*StreamStats{}
+	}
+	s, ok := loadStats.Stream[name]
+	if !ok {
+		s = &StreamStats{}
+		loadStats.Stream[name] = s
+	}
+	return s
+}
+
+// streamClient sends requests of streaming responses, fasthttp reads the
+// whole response before returning.
+var streamClient *http.Client
+
+func newStreamClient(runnerConfig *RunnerConfig, tlsConfig *tls.Config) *http.Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialConn(addr)
+		},
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 1024,
+		DisableKeepAlives:   runnerConfig.DisableKeepAlive,
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (s *StreamConfig) init(url string) error {
+	if s.Name == "" {
+		s.Name = url
+	}
+	switch s.Format {
+	case "":
+		s.Format = streamSSE
+	case streamSSE, streamNDJSON:
+	default:
+		return fmt.Errorf("unsupported stream format [%s]", s.Format)
+	}
+	if s.TimeoutInMilliSeconds <= 0 {
+		s.TimeoutInMilliSeconds = 60000
+	}
+	var err error
+	if s.EventAssert != nil {
+		if s.eventAssert, err = conditions.NewCondition(s.EventAssert); err != nil {
+			return fmt.Errorf("invalid event_assert of stream [%s]: %v", s.Name, err)
+		}
+	}
+	if s.StopOn != nil {
+		if s.stopOn, err = conditions.NewCondition(s.StopOn); err != nil {
+			return fmt.Errorf("invalid stop_on of stream [%s]: %v", s.Name, err)
+		}
+	}
+	return nil
+}
+
+type streamEvent struct {
+	name string
+	id   string
+	data string
+}
+
+func (event *streamEvent) ctx(index int, elapsed time.Duration) util.MapStr {
+	ctx := util.MapStr{
+		"_ctx": map[string]interface{}{
+			"response": map[string]interface{}{
+				"event":       event.name,
+				"id":          event.id,
+				"index":       index,
+				"body":        event.data,
+				"body_length": len(event.data),
+			},
+			"elapsed": int64(elapsed / time.Millisecond),
+		},
+	}
+	putBodyJson(ctx, "_ctx.response.body_json", []byte(event.data))
+	return ctx
+}
+
+// eventReader parses events of the stream.
+type eventReader struct {
+	reader *bufio.Reader
+	format string
+}
+
+func newEventReader(r io.Reader, format string) *eventReader {
+	return &eventReader{reader: bufio.NewReaderSize(r, 65536), format: format}
+}
+
+// next returns the next event, an incomplete SSE event at the end of the
+// stream is dropped.
+func (r *eventReader) next() (*streamEvent, error) {
+	event := &streamEvent{}
+	var data []string
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if r.format == streamNDJSON {
+			if line == "" {
+				continue
+			}
+			return &streamEvent{data: line}, nil
+		}
+
+		if line == "" {
+			if len(data) > 0 {
+				event.data = strings.Join(data, "\n")
+				return event, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event.name = value
+		case "id":
+			event.id = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// newStreamRequest converts the prepared request.
+func newStreamRequest(ctx context.Context, item *RequestItem, req *fasthttp.Request) (*http.Request, func(), error) {
+	var body io.Reader
+	closeBody := func() {}
+	stream := loadBodyStream(req)
+	if stream != nil {
+		reader := stream.open()
+		body, closeBody = reader, func() { reader.Close() }
+	} else if len(req.Body()) > 0 {
+		body = bytes.NewReader(req.Body())
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()), req.URI().String(), body)
+	if err != nil {
+		closeBody()
+		return nil, nil, err
+	}
+	if stream != nil {
+		httpReq.ContentLength = int64(req.Header.ContentLength())
+	}
+	req.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case fasthttp.HeaderHost:
+			httpReq.Host = string(v)
+		case fasthttp.HeaderContentLength:
+		default:
+			httpReq.Header.Add(string(k), string(v))
+		}
+	})
+	if item.Request.Stream.Format == streamSSE && httpReq.Header.Get("Accept") == "" {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	return httpReq, closeBody, nil
+}
+
+func doStream(config *LoaderConfig, globalCtx util.MapStr, req *fasthttp.Request, resp *fasthttp.Response, item *RequestItem, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
+	s := item.Request.Stream
+	scenario := loadStats.streamStats(s.Name)
+	client := streamClient
+	if item.Request.streamClient != nil {
+		client = item.Request.streamClient
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.TimeoutInMilliSeconds)*time.Millisecond)
+	defer cancel()
+	httpReq, closeBody, err := newStreamRequest(ctx, item, req)
+	if err != nil {
+		return true, err
+	}
+	defer closeBody()
+
+	start := time.Now()
+	httpResp, err := client.Do(httpReq)
+	statusCode := 0
+	if httpResp != nil {
+		statusCode = httpResp.StatusCode
+	}
+	if config.RunnerConfig.LogRequests || util.ContainsInAnyInt32Array(statusCode, config.RunnerConfig.LogStatusCodes) {
+		log.Infof("[%v] %v, %v", item.Request.Method, httpReq.URL, item.Request.Headers)
+		log.Infof("status: %v, error: %v", statusCode, err)
+	}
+	if err != nil {
+		loadStats.NumErrs++
+		loadStats.NumAssertInvalid++
+		loadStats.NumRequests++
+		loadStats.StatusCode[statusCode] += 1
+		return true, err
+	}
+	defer httpResp.Body.Close()
+
+	// The status and headers are used by the cookie jar
+	resp.Reset()
+	resp.SetStatusCode(statusCode)
+	for k, values := range httpResp.Header {
+		for _, v := range values {
+			resp.Header.Add(k, v)
+		}
+	}
+	if jar := cookieJarOf(globalCtx); jar != nil {
+		jar.update(globalCtx, req, resp)
+	}
+
+	var lastEvent *streamEvent
+	var firstEvent time.Duration
+	events := 0
+	invalid := false
+	matched := false
+	counter := &countingReader{Reader: httpResp.Body}
+	if statusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(counter, 65536))
+		lastEvent = &streamEvent{data: string(data)}
+	} else {
+		reader := newEventReader(counter, s.Format)
+		last := start
+		for {
+			event, readErr := reader.next()
+			if readErr != nil {
+				if ctx.Err() != nil {
+					scenario.Timeouts++
+				} else if readErr != io.EOF {
+					err = readErr
+				}
+				break
+			}
+			now := time.Now()
+			if events == 0 {
+				firstEvent = now.Sub(start)
+				scenario.addFirstEvent(firstEvent)
+				if !config.RunnerConfig.NoStats {
+					stats.Timing("stream", "first_event", firstEvent.Milliseconds())
+				}
+			} else {
+				scenario.addGap(now.Sub(last))
+			}
+			last = now
+			lastEvent = event
+			events++
+			scenario.Events++
+
+			if s.eventAssert == nil && s.stopOn == nil {
+				continue
+			}
+			eventCtx := event.ctx(events-1, now.Sub(start))
+			if s.eventAssert != nil && !s.eventAssert.Check(eventCtx) {
+				scenario.InvalidEvents++
+				invalid = true
+				log.Errorf("stream [%s] event #%d is invalid: %s", s.Name, events-1, util.SubString(event.data, 0, 512))
+				break
+			}
+			if s.stopOn != nil && s.stopOn.Check(eventCtx) {
+				matched = true
+				break
+			}
+		}
+		if !invalid && err == nil && s.stopOn != nil && !matched {
+			invalid = true
+			log.Errorf("stream [%s] ended without the stop_on event after %d events", s.Name, events)
+		}
+	}
+
+	duration := time.Since(start)
+	scenario.Streams++
+	scenario.TotStreamTime += duration
+	if !config.RunnerConfig.BenchmarkOnly && timer != nil {
+		timer.AddTime(duration)
+	}
+	if !config.RunnerConfig.NoStats {
+		stats.Timing("stream", "duration", duration.Milliseconds())
+		stats.Increment("request", "total")
+		stats.Increment("request", util.ToString(statusCode))
+	}
+	if err != nil {
+		loadStats.NumErrs++
+	}
+	if !config.RunnerConfig.NoSizeStats {
+		loadStats.TotReqSize += int64(req.GetRequestLength())
+		loadStats.TotRespSize += counter.n
+	}
+	loadStats.NumRequests++
+	loadStats.TotDuration += duration
+	loadStats.MaxRequestTime = util.MaxDuration(duration, loadStats.MaxRequestTime)
+	loadStats.MinRequestTime = util.MinDuration(duration, loadStats.MinRequestTime)
+	loadStats.StatusCode[statusCode] += 1
+
+	if invalid {
+		loadStats.NumAssertInvalid++
+		if !config.RunnerConfig.ContinueOnAssertInvalid {
+			return false, err
+		}
+	}
+
+	if item.Register != nil || item.Assert != nil {
+		if lastEvent == nil {
+			lastEvent = &streamEvent{}
+		}
+		event := buildCtx(resp, []byte(lastEvent.data), duration)
+		event.Put("_ctx.response.events", events)
+		event.Put("_ctx.response.raw_body_length", counter.n)
+		if events > 0 {
+			event.Put("_ctx.response.first_event_elapsed", int64(firstEvent/time.Millisecond))
+		}
+		if next, _ := item.registerAndAssert(config, globalCtx, event, len(lastEvent.data), loadStats); !next {
+			return false, err
+		}
+	}
+
+	if item.Sleep != nil {
+		time.Sleep(time.Duration(item.Sleep.SleepInMilliSeconds) * time.Millisecond)
+	}
+	return true, err
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,123 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestEventReader(t *testing.T) {
+	reader := newEventReader(strings.NewReader(": comment\nevent: delta\nid: 1\ndata: {\"text\":\ndata: \"hi\"}\n\ndata:done\r\n\r\ndata: incomplete"), streamSSE)
+	event, err := reader.next()
+	if err != nil || event.name != "delta" || event.id != "1" || event.data != "{\"text\":\n\"hi\"}" {
+		t.Errorf("unexpected event: %+v, %v", event, err)
+	}
+	event, err = reader.next()
+	if err != nil || event.data != "done" {
+		t.Errorf("unexpected event: %+v, %v", event, err)
+	}
+	if _, err = reader.next(); err != io.EOF {
+		t.Errorf("expected incomplete event to be dropped, got %v", err)
+	}
+
+	reader = newEventReader(strings.NewReader("{\"a\":1}\n\n{\"a\":2}"), streamNDJSON)
+	for _, expected := range []string{`{"a":1}`, `{"a":2}`} {
+		if event, err = reader.next(); err != nil || event.data != expected {
+			t.Errorf("unexpected event: %+v, %v", event, err)
+		}
+	}
+	if _, err = reader.next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDoStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: {\"index\": %d}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+	}))
+	defer server.Close()
+
+	config := &LoaderConfig{
+		Requests: []RequestItem{
+			{Request: &Request{Method: "GET", Url: server.URL + "/chat", Stream: &StreamConfig{Name: "chat"}}},
+			{Request: &Request{Method: "GET", Url: server.URL + "/slow", Stream: &StreamConfig{Name: "slow", TimeoutInMilliSeconds: 200}}},
+		},
+		RunnerConfig: RunnerConfig{NoStats: true},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+	streamClient = newStreamClient(&config.RunnerConfig, nil)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	for i := range config.Requests {
+		item := &config.Requests[i]
+		req.Reset()
+		req.Header.SetMethod(item.Request.Method)
+		req.SetRequestURI(item.Request.Url)
+		if next, err := doStream(config, util.MapStr{}, req, resp, item, loadStats, nil); !next || err != nil {
+			t.Fatalf("unexpected result, next: %v, err: %v", next, err)
+		}
+	}
+
+	chat := loadStats.Stream["chat"]
+	if chat.Streams != 1 || chat.Events != 3 || chat.FirstEvents != 1 || chat.Gaps != 2 || chat.Timeouts != 0 {
+		t.Errorf("unexpected stats: %+v", chat)
+	}
+	if chat.MaxGapTime < 5*time.Millisecond || chat.TotStreamTime < chat.TotFirstEventTime {
+		t.Errorf("unexpected timing: %+v", chat)
+	}
+	slow := loadStats.Stream["slow"]
+	if slow.Events != 3 || slow.Timeouts != 1 || slow.TotStreamTime > 900*time.Millisecond {
+		t.Errorf("unexpected stats of timeout: %+v", slow)
+	}
+	if loadStats.StatusCode[http.StatusOK] != 2 || loadStats.NumErrs != 0 {
+		t.Errorf("unexpected status codes: %v, errors: %v", loadStats.StatusCode, loadStats.NumErrs)
+	}
+}
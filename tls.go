@@ -0,0 +1,148 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// TLSConfig configures TLS connections, the server certificate is not
+// verified by default.
+type TLSConfig struct {
+	// Verify the server certificate, default: false
+	Verify bool `config:"verify"`
+	// PEM encoded CA certificates to verify the server, default: system CAs
+	CAFile string `config:"ca_file"`
+	// PEM encoded client certificate and key for mTLS
+	CertFile string `config:"cert_file"`
+	KeyFile  string `config:"key_file"`
+	// Server name to send in SNI and verify, default: host of the request
+	ServerName string `config:"server_name"`
+	// TLS versions: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `config:"min_version"`
+	MaxVersion string `config:"max_version"`
+	// Cipher suites of TLS 1.0-1.2, e.g.: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	CipherSuites []string `config:"cipher_suites"`
+	// Resume sessions of previous connections instead of full handshakes
+	SessionResumption bool `config:"session_resumption"`
+}
+
+// tlsStats counts the TLS handshakes of all clients.
+var tlsStats struct {
+	handshakes int64
+	resumed    int64
+}
+
+// resetTLSStats clears tlsStats before a run.
+func resetTLSStats() {
+	atomic.StoreInt64(&tlsStats.handshakes, 0)
+	atomic.StoreInt64(&tlsStats.resumed, 0)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the client TLS config, the default config is returned if
+// c is nil.
+func newTLSConfig(c *TLSConfig) (*tls.Config, error) {
+	if c == nil {
+		c = &TLSConfig{}
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: !c.Verify,
+		ServerName:         c.ServerName,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			atomic.AddInt64(&tlsStats.handshakes, 1)
+			if state.DidResume {
+				atomic.AddInt64(&tlsStats.resumed, 1)
+			}
+			return nil
+		},
+	}
+
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no valid certificate found in [%s]", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	var ok bool
+	if c.MinVersion != "" {
+		if config.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+			return nil, fmt.Errorf("unsupported tls version [%s]", c.MinVersion)
+		}
+	}
+	if c.MaxVersion != "" {
+		if config.MaxVersion, ok = tlsVersions[c.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unsupported tls version [%s]", c.MaxVersion)
+		}
+	}
+
+	if len(c.CipherSuites) > 0 {
+		suites := map[string]uint16{}
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported cipher suite [%s]", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+
+	if c.SessionResumption {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		// fasthttp sets its own session cache if there is none
+		config.SessionTicketsDisabled = true
+	}
+	return config, nil
+}
@@ -0,0 +1,121 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestTLSVerifyAndResumption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest := func(config *TLSConfig) error {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Close the connection after each request to force a new handshake
+		client := &fasthttp.Client{TLSConfig: tlsConfig, Dial: dialConn}
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI(server.URL)
+		req.SetConnectionClose()
+		for i := 0; i < 3; i++ {
+			if err := client.Do(req, resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := doRequest(&TLSConfig{Verify: true}); err == nil {
+		t.Error("expected verification error without the CA")
+	}
+
+	handshakes := atomic.LoadInt64(&tlsStats.handshakes)
+	resumed := atomic.LoadInt64(&tlsStats.resumed)
+	if err := doRequest(&TLSConfig{Verify: true, CAFile: caFile, ServerName: "example.com", SessionResumption: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&tlsStats.handshakes) - handshakes; n != 3 {
+		t.Errorf("expected 3 handshakes, got %v", n)
+	}
+	if n := atomic.LoadInt64(&tlsStats.resumed) - resumed; n == 0 {
+		t.Error("expected resumed sessions")
+	}
+
+	handshakes = atomic.LoadInt64(&tlsStats.handshakes)
+	resumed = atomic.LoadInt64(&tlsStats.resumed)
+	if err := doRequest(&TLSConfig{Verify: true, CAFile: caFile, ServerName: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&tlsStats.handshakes) - handshakes; n != 3 {
+		t.Errorf("expected 3 handshakes, got %v", n)
+	}
+	if n := atomic.LoadInt64(&tlsStats.resumed) - resumed; n != 0 {
+		t.Errorf("expected no resumed sessions, got %v", n)
+	}
+}
+
+func TestTLSConfigOptions(t *testing.T) {
+	config, err := newTLSConfig(&TLSConfig{
+		MinVersion:   "1.2",
+		MaxVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.InsecureSkipVerify || config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected config: %+v", config)
+	}
+	if len(config.CipherSuites) != 1 || config.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites: %v", config.CipherSuites)
+	}
+
+	if _, err := newTLSConfig(&TLSConfig{MinVersion: "2.0"}); err == nil {
+		t.Error("expected error of unsupported version")
+	}
+	if _, err := newTLSConfig(&TLSConfig{CipherSuites: []string{"unknown"}}); err == nil {
+		t.Error("expected error of unsupported cipher suite")
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/gorilla/websocket"
+	"github.com/jamiealquiza/tachymeter"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasttemplate"
+)
+
+// WebSocketRequest connects to a WebSocket server and runs a conversation.
+type WebSocketRequest struct {
+	// Name of the scenario in the summary, default: url
+	Name    string              `config:"name"`
+	Url     string              `config:"url"`
+	Headers []map[string]string `config:"headers"`
+
+	RuntimeVariables map[string]string `config:"runtime_variables"`
+
+	Steps []WebSocketStep `config:"steps"`
+
+	// Keep the connection open after all steps finished, messages received
+	// meanwhile are counted
+	HoldInMilliSeconds int64 `config:"hold_in_milli_seconds"`
+	// How long to wait for an expected message, default: 5000
+	TimeoutInMilliSeconds int64 `config:"timeout_in_milli_seconds"`
+
+	urlTemplate      *fasttemplate.Template
+	headerTemplates  map[string]*fasttemplate.Template
+	runtimeVariables *orderedVariables
+}
+
+// WebSocketStep sends a frame and/or waits for a message.
+type WebSocketStep struct {
+	// Frame to send, supports variables
+	Send string `config:"send"`
+	// Send the frame as a binary message
+	Binary bool `config:"binary"`
+	// Wait until a message matches the conditions, messages are accessed by
+	// `_ctx.response.body` and `_ctx.response.body_json`
+	Expect *conditions.Config `config:"expect"`
+
+	sendTemplate *fasttemplate.Template
+	expect       conditions.Condition
+}
+
+type WebSocketStats struct {
+	Connections      int
+	ConnectErrors    int
+	TotConnectTime   time.Duration
+	MessagesSent     int
+	MessagesReceived int
+	RoundTrips       int
+	TotRoundTripTime time.Duration
+	MinRoundTripTime time.Duration
+	MaxRoundTripTime time.Duration
+}
+
+func (s *WebSocketStats) addRoundTrip(duration time.Duration) {
+	if s.RoundTrips == 0 || duration < s.MinRoundTripTime {
+		s.MinRoundTripTime = duration
+	}
+	s.MaxRoundTripTime = util.MaxDuration(duration, s.MaxRoundTripTime)
+	s.TotRoundTripTime += duration
+	s.RoundTrips++
+}
+
+func (s *WebSocketStats) merge(other *WebSocketStats) {
+	if other.RoundTrips > 0 && (s.RoundTrips == 0 || other.MinRoundTripTime < s.MinRoundTripTime) {
+		s.MinRoundTripTime = other.MinRoundTripTime
+	}
+	s.MaxRoundTripTime = util.MaxDuration(other.MaxRoundTripTime, s.MaxRoundTripTime)
+	s.Connections += other.Connections
+	s.ConnectErrors += other.ConnectErrors
+	s.TotConnectTime += other.TotConnectTime
+	s.MessagesSent += other.MessagesSent
+	s.MessagesReceived += other.MessagesReceived
+	s.RoundTrips += other.RoundTrips
+	s.TotRoundTripTime += other.TotRoundTripTime
+}
+
+var webSocketDialer *websocket.Dialer
+
+func newWebSocketDialer(tlsConfig *tls.Config) *websocket.Dialer {
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return dialConn(addr)
+		},
+		TLSClientConfig: tlsConfig,
+	}
+	if timeout > 0 {
+		dialer.HandshakeTimeout = time.Duration(timeout) * time.Second
+	}
+	return dialer
+}
+
+func (ws *WebSocketRequest) init() error {
+	if ws.Url == "" {
+		return fmt.Errorf("websocket url is not set")
+	}
+	if ws.Name == "" {
+		ws.Name = ws.Url
+	}
+	if ws.TimeoutInMilliSeconds <= 0 {
+		ws.TimeoutInMilliSeconds = 5000
+	}
+
+	ws.runtimeVariables = newOrderedVariables(ws.RuntimeVariables)
+	var err error
+	if ws.urlTemplate, err = newTemplate(ws.Url); err != nil {
+		return err
+	}
+	ws.headerTemplates = map[string]*fasttemplate.Template{}
+	for _, headers := range ws.Headers {
+		for k, v := range headers {
+			if ws.headerTemplates[k], err = newTemplate(v); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range ws.Steps {
+		step := &ws.Steps[i]
+		if step.sendTemplate, err = newTemplate(step.Send); err != nil {
+			return err
+		}
+		if step.Expect != nil {
+			if step.expect, err = conditions.NewCondition(step.Expect); err != nil {
+				return fmt.Errorf("invalid expect of websocket [%s] step #%d: %v", ws.Name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveUrl uses the default endpoint if the url has no host.
+func (ws *WebSocketRequest) resolveUrl(config *LoaderConfig, url string) string {
+	if !strings.HasPrefix(url, "/") {
+		return url
+	}
+	endpoint, err := config.RunnerConfig.parseDefaultEndpoint()
+	if err != nil {
+		return url
+	}
+	scheme := "ws"
+	if string(endpoint.Scheme()) == "https" {
+		scheme = "wss"
+	}
+	return scheme + "://" + string(endpoint.Host()) + url
+}
+
+func (loadStats *LoadStats) webSocketStats(name string) *WebSocketStats {
+	if loadStats.WebSocket == nil {
+		loadStats.WebSocket = map[string]*WebSocketStats{}
+	}
+	s, ok := loadStats.WebSocket[name]
+	if !ok {
+		s = &WebSocketStats{}
+		loadStats.WebSocket[name] = s
+	}
+	return s
+}
+
+func doWebSocket(config *LoaderConfig, globalCtx util.MapStr, item *RequestItem, loadStats *LoadStats, timer *tachymeter.Tachymeter) (continueNext bool, err error) {
+	ws := item.WebSocket
+	scenario := loadStats.webSocketStats(ws.Name)
+
+	runtimeVariables := util.MapStr{}
+	runtimeVariables.Update(globalCtx)
+	ws.runtimeVariables.put(runtimeVariables)
+
+	url := ws.resolveUrl(config, renderTemplate(ws.urlTemplate, ws.Url, runtimeVariables))
+	header := http.Header{}
+	for _, headers := range ws.Headers {
+		for k, v := range headers {
+			header.Add(k, renderTemplate(ws.headerTemplates[k], v, runtimeVariables))
+		}
+	}
+	if exhaustedIn(globalCtx) {
+		return false, nil
+	}
+
+	start := time.Now()
+	conn, handshake, err := webSocketDialer.Dial(url, header)
+	connectTime := time.Since(start)
+	statusCode := 0
+	if handshake != nil {
+		statusCode = handshake.StatusCode
+	}
+
+	if config.RunnerConfig.LogRequests || util.ContainsInAnyInt32Array(statusCode, config.RunnerConfig.LogStatusCodes) {
+		log.Infof("[WEBSOCKET] %v, %v", url, ws.Headers)
+		log.Infof("status: %v, error: %v, connect time: %v", statusCode, err, connectTime)
+	}
+
+	if err != nil {
+		scenario.ConnectErrors++
+		loadStats.NumErrs++
+		loadStats.NumAssertInvalid++
+		loadStats.NumRequests++
+		loadStats.StatusCode[statusCode] += 1
+		return true, err
+	}
+	defer conn.Close()
+
+	if !config.RunnerConfig.NoStats {
+		stats.Timing("websocket", "connect", connectTime.Milliseconds())
+	}
+	scenario.Connections++
+	scenario.TotConnectTime += connectTime
+
+	var lastMessage []byte
+	received := 0
+	continueNext = true
+	readTimeout := time.Duration(ws.TimeoutInMilliSeconds) * time.Millisecond
+	for i := range ws.Steps {
+		step := &ws.Steps[i]
+		sent := time.Now()
+		if step.Send != "" {
+			messageType := websocket.TextMessage
+			if step.Binary {
+				messageType = websocket.BinaryMessage
+			}
+			payload := renderTemplate(step.sendTemplate, step.Send, runtimeVariables)
+			if exhaustedIn(globalCtx) {
+				continueNext = false
+				break
+			}
+			sent = time.Now()
+			if err = conn.WriteMessage(messageType, []byte(payload)); err != nil {
+				break
+			}
+			scenario.MessagesSent++
+		}
+		if step.expect == nil {
+			continue
+		}
+
+		matched := false
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		for !matched {
+			var message []byte
+			_, message, err = conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			received++
+			scenario.MessagesReceived++
+			lastMessage = message
+			matched = step.expect.Check(buildMessageCtx(message))
+		}
+		if !matched {
+			loadStats.NumAssertInvalid++
+			log.Errorf("websocket [%s] step #%d, expected message not received, error: %v", ws.Name, i, err)
+			if isTimeout(err) {
+				err = nil
+			}
+			continueNext = config.RunnerConfig.ContinueOnAssertInvalid
+			break
+		}
+		if step.Send != "" {
+			roundTrip := time.Since(sent)
+			scenario.addRoundTrip(roundTrip)
+			if !config.RunnerConfig.NoStats {
+				stats.Timing("websocket", "round_trip", roundTrip.Milliseconds())
+			}
+		}
+	}
+
+	if err == nil && continueNext && ws.HoldInMilliSeconds > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(ws.HoldInMilliSeconds) * time.Millisecond))
+		for {
+			var message []byte
+			_, message, err = conn.ReadMessage()
+			if err != nil {
+				if isTimeout(err) {
+					err = nil
+				}
+				break
+			}
+			received++
+			scenario.MessagesReceived++
+			lastMessage = message
+		}
+	}
+
+	if err == nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	} else {
+		loadStats.NumErrs++
+	}
+
+	duration := time.Since(start)
+	if !config.RunnerConfig.BenchmarkOnly && timer != nil {
+		timer.AddTime(duration)
+	}
+	loadStats.NumRequests++
+	loadStats.TotDuration += duration
+	loadStats.MaxRequestTime = util.MaxDuration(duration, loadStats.MaxRequestTime)
+	loadStats.MinRequestTime = util.MinDuration(duration, loadStats.MinRequestTime)
+	loadStats.StatusCode[statusCode] += 1
+
+	if !continueNext {
+		return false, err
+	}
+
+	if item.Register != nil || item.Assert != nil {
+		responseHeader := map[string]interface{}{}
+		for k := range handshake.Header {
+			responseHeader[k] = handshake.Header.Get(k)
+		}
+		event := util.MapStr{
+			"_ctx": map[string]interface{}{
+				"response": map[string]interface{}{
+					"status":      statusCode,
+					"header":      responseHeader,
+					"body":        string(lastMessage),
+					"body_length": len(lastMessage),
+					"messages":    received,
+				},
+				"elapsed": int64(duration / time.Millisecond),
+			},
+		}
+		putBodyJson(event, "_ctx.response.body_json", lastMessage)
+		if next, _ := item.registerAndAssert(config, globalCtx, event, len(lastMessage), loadStats); !next {
+			return false, err
+		}
+	}
+
+	if item.Sleep != nil {
+		time.Sleep(time.Duration(item.Sleep.SleepInMilliSeconds) * time.Millisecond)
+	}
+
+	return true, err
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func buildMessageCtx(message []byte) util.MapStr {
+	event := util.MapStr{
+		"_ctx": map[string]interface{}{
+			"response": map[string]interface{}{
+				"body":        string(message),
+				"body_length": len(message),
+			},
+		},
+	}
+	putBodyJson(event, "_ctx.response.body_json", message)
+	return event
+}
@@ -0,0 +1,164 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Loadgen is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"infini.sh/framework/core/conditions"
+	coreConfig "infini.sh/framework/core/config"
+	"infini.sh/framework/core/util"
+)
+
+func TestWebSocketConversation(t *testing.T) {
+	server := newWebSocketServer(true)
+	defer server.Close()
+
+	webSocketDialer = newWebSocketDialer(nil)
+	config := &LoaderConfig{
+		Variable: []Variable{{Name: "user", Type: "list", Data: []string{"medcl"}}},
+		Requests: []RequestItem{{
+			WebSocket: &WebSocketRequest{
+				Name:               "echo",
+				Url:                "ws" + strings.TrimPrefix(server.URL, "http") + "/chat",
+				Steps:              []WebSocketStep{{Send: `{"user": "$[[user]]"}`}},
+				HoldInMilliSeconds: 100,
+			},
+		}},
+	}
+	if err := config.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadStats := &LoadStats{MinRequestTime: time.Millisecond, StatusCode: map[int]int{}}
+	next, err := doWebSocket(config, util.MapStr{}, &config.Requests[0], loadStats, nil)
+	if !next || err != nil {
+		t.Fatalf("unexpected result, next: %v, err: %v", next, err)
+	}
+
+	ws := loadStats.WebSocket["echo"]
+	if ws.Connections != 1 || ws.MessagesSent != 1 || ws.MessagesReceived != 1 {
+		t.Errorf("unexpected stats: %+v", ws)
+	}
+	if loadStats.StatusCode[http.StatusSwitchingProtocols] != 1 {
+		t.Errorf("unexpected status codes: %v", loadStats.StatusCode)
+	}
+}
+
+func newTestCondition(t *testing.T, yml string) *conditions.Config {
+	parsed, err := coreConfig.NewConfigWithYAML([]byte(yml), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition := &conditions.Config{}
+	if err = parsed.Unpack(condition); err != nil {
+		t.Fatal(err)
+	}
+	return condition
+}
+
+func newWebSocketServer(echo bool) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if echo {
+				conn.WriteMessage(messageType, message)
+			}
+		}
+	}))
+}
+
+func TestWebSocketExpect(t *testing.T) {
+	echoServer := newWebSocketServer(true)
+	defer echoServer.Close()
+	silentServer := newWebSocketServer(false)
+	defer silentServer.Close()
+	webSocketDialer = newWebSocketDialer(nil)
+
+	tests := []struct {
+		name       string
+		server     *httptest.Server
+		send       string
+		expect     string
+		continueOn bool
+		next       bool
+		received   int
+		roundTrips int
+		invalid    int
+	}{
+		{"match", echoServer, `{"user": "medcl"}`, `equals: {_ctx.response.body_json.user: medcl}`, false, true, 1, 1, 0},
+		{"mismatch", echoServer, `{"user": "medcl"}`, `equals: {_ctx.response.body_json.user: other}`, false, false, 1, 0, 1},
+		{"mismatch continue", echoServer, `{"user": "medcl"}`, `equals: {_ctx.response.body_json.user: other}`, true, true, 1, 0, 1},
+		{"receive timeout", silentServer, "", `contains: {_ctx.response.body: medcl}`, false, false, 0, 0, 1},
+	}
+	for _, test := range tests {
+		config := &LoaderConfig{
+			RunnerConfig: RunnerConfig{ContinueOnAssertInvalid: test.continueOn},
+			Requests: []RequestItem{{
+				WebSocket: &WebSocketRequest{
+					Name:                  test.name,
+					Url:                   "ws" + strings.TrimPrefix(test.server.URL, "http") + "/chat",
+					Steps:                 []WebSocketStep{{Send: test.send, Expect: newTestCondition(t, test.expect)}},
+					TimeoutInMilliSeconds: 100,
+				},
+			}},
+		}
+		if err := config.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		loadStats := &LoadStats{MinRequestTime: time.Minute, StatusCode: map[int]int{}}
+		start := time.Now()
+		next, err := doWebSocket(config, util.MapStr{}, &config.Requests[0], loadStats, nil)
+		elapsed := time.Since(start)
+		if next != test.next || err != nil {
+			t.Errorf("%s: unexpected result, next: %v, err: %v", test.name, next, err)
+		}
+		if test.invalid > 0 && (elapsed < 100*time.Millisecond || elapsed > 2*time.Second) {
+			t.Errorf("%s: expected to wait for the receive timeout, took %v", test.name, elapsed)
+		}
+
+		ws := loadStats.WebSocket[test.name]
+		if ws.MessagesReceived != test.received || ws.RoundTrips != test.roundTrips || loadStats.NumAssertInvalid != test.invalid {
+			t.Errorf("%s: unexpected stats: %+v, assert invalid: %v", test.name, ws, loadStats.NumAssertInvalid)
+		}
+		if ws.RoundTrips > 0 && (ws.MinRoundTripTime <= 0 || ws.MinRoundTripTime > ws.MaxRoundTripTime || ws.TotRoundTripTime < ws.MaxRoundTripTime) {
+			t.Errorf("%s: unexpected round trip times: %+v", test.name, ws)
+		}
+	}
+}